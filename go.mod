@@ -5,6 +5,9 @@ go 1.22.6
 require (
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.34.4
 	github.com/awslabs/aws-lambda-go-api-proxy v0.16.2
+	github.com/gorilla/websocket v1.5.0
+	golang.org/x/crypto v0.23.0
+	golang.org/x/sync v0.8.0
 )
 
 require (
@@ -23,7 +26,6 @@ require (
 	github.com/aws/smithy-go v1.20.3 // indirect
 	github.com/bitly/go-simplejson v0.5.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
@@ -60,7 +62,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
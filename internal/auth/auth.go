@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"hermes-crypto-core/internal/models"
+)
+
+// TokenTTL is how long an issued token stays valid
+var TokenTTL = 24 * time.Hour
+
+var secret []byte
+
+// Init loads the signing secret from AUTH_SECRET and the token lifetime from AUTH_TOKEN_TTL.
+// Without a secret a random one is generated, so tokens do not survive a restart or carry over
+// between Lambda instances; deploys check that one is configured.
+func Init() {
+	secret = []byte(os.Getenv("AUTH_SECRET"))
+	if len(secret) == 0 {
+		if os.Getenv("IS_LOCAL") != "true" {
+			log.Println("WARNING: AUTH_SECRET is not set outside local development; tokens are only valid on the instance that issued them")
+		}
+		log.Println("AUTH_SECRET is not set, generating a random secret; tokens will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Unable to generate auth secret: %v", err)
+		}
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("AUTH_TOKEN_TTL")); err == nil && ttl > 0 {
+		TokenTTL = ttl
+	}
+}
+
+// IssueToken returns a signed token identifying the user until TokenTTL has passed
+func IssueToken(userId string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userId + "|" + strconv.FormatInt(time.Now().Add(TokenTTL).Unix(), 10)))
+	return payload + "." + sign(payload)
+}
+
+// VerifyToken checks the signature and expiry of a token and returns the id of the user it identifies
+func VerifyToken(token string) (string, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(payload))) {
+		return "", models.ReturnError{ErrorMessage: "Invalid token"}
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", models.ReturnError{ErrorMessage: "Invalid token"}
+	}
+	userId, expiry, ok := strings.Cut(string(decoded), "|")
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || userId == "" {
+		return "", models.ReturnError{ErrorMessage: "Invalid token"}
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", models.ReturnError{ErrorMessage: "Token has expired"}
+	}
+	return userId, nil
+}
+
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,165 @@
+package coin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// FailureThreshold is the number of consecutive failures after which a provider is skipped
+var FailureThreshold = 3
+
+// BreakerCooldown is how long a provider is skipped before a single trial call is let through
+var BreakerCooldown = 30 * time.Second
+
+// ProviderTimeout bounds a single call to a provider
+var ProviderTimeout = 3 * time.Second
+
+// circuitBreaker tracks the health of a single provider
+type circuitBreaker struct {
+	state               string
+	consecutiveFailures int
+	totalFailures       int
+	totalSuccesses      int
+	lastError           string
+	lastFailureAt       time.Time
+	openedAt            time.Time
+	trialInFlight       bool
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*circuitBreaker{}
+)
+
+// breakerFor returns the breaker of a provider, creating a closed one if needed. Callers hold breakersMu.
+func breakerFor(provider string) *circuitBreaker {
+	breaker, ok := breakers[provider]
+	if !ok {
+		breaker = &circuitBreaker{state: con.BREAKER_CLOSED}
+		breakers[provider] = breaker
+	}
+	return breaker
+}
+
+// allowCall reports whether a provider may be called. An open breaker lets a single trial call
+// through (half-open) once the cooldown has passed.
+func allowCall(provider string) bool {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	breaker := breakerFor(provider)
+	switch breaker.state {
+	case con.BREAKER_OPEN:
+		if time.Since(breaker.openedAt) < BreakerCooldown {
+			return false
+		}
+		breaker.state = con.BREAKER_HALF_OPEN
+		breaker.trialInFlight = true
+		return true
+	case con.BREAKER_HALF_OPEN:
+		if breaker.trialInFlight {
+			return false
+		}
+		breaker.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// recordResult updates the breaker of a provider with the outcome of a call
+func recordResult(provider string, err error) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	breaker := breakerFor(provider)
+	breaker.trialInFlight = false
+	if err == nil {
+		breaker.totalSuccesses++
+		breaker.consecutiveFailures = 0
+		breaker.state = con.BREAKER_CLOSED
+		return
+	}
+
+	breaker.totalFailures++
+	breaker.consecutiveFailures++
+	breaker.lastError = err.Error()
+	breaker.lastFailureAt = time.Now()
+	// A failed trial call opens the breaker again straight away
+	if breaker.state == con.BREAKER_HALF_OPEN || breaker.consecutiveFailures >= FailureThreshold {
+		breaker.state = con.BREAKER_OPEN
+		breaker.openedAt = time.Now()
+	}
+}
+
+// releaseCall gives back a call that ended without telling us anything about the provider's health
+func releaseCall(provider string) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	breakerFor(provider).trialInFlight = false
+}
+
+// guardedCall calls a provider through its circuit breaker, bounded by ProviderTimeout, and counts
+// the call against the provider's quota. Calls that end because the caller cancelled its context do
+// not count against the provider's health.
+func guardedCall[T any](ctx context.Context, provider PriceProvider, call func(ctx context.Context) (T, error)) (T, error) {
+	if !allowCall(provider.Name()) {
+		var zero T
+		return zero, models.ReturnError{ErrorMessage: "Price provider " + provider.Name() + " is unavailable, circuit is open"}
+	}
+	// Quota is only spent on calls that are made. An exhausted quota says nothing about the provider's
+	// health, so the call is given back to the breaker.
+	if err := spendQuota(ctx, provider.Name()); err != nil {
+		releaseCall(provider.Name())
+		var zero T
+		return zero, err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, ProviderTimeout)
+	defer cancel()
+	result, err := call(callCtx)
+	if err != nil && ctx.Err() != nil {
+		releaseCall(provider.Name())
+		return result, err
+	}
+	recordResult(provider.Name(), err)
+	return result, err
+}
+
+// GetProviderHealth returns the circuit breaker state of every provider in the chain, in chain order
+func GetProviderHealth() []models.ProviderHealth {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	health := make([]models.ProviderHealth, 0, len(Providers))
+	for _, provider := range Providers {
+		breaker := breakerFor(provider.Name())
+		providerHealth := models.ProviderHealth{
+			Provider:            provider.Name(),
+			State:               breaker.state,
+			ConsecutiveFailures: breaker.consecutiveFailures,
+			TotalFailures:       breaker.totalFailures,
+			TotalSuccesses:      breaker.totalSuccesses,
+			LastError:           breaker.lastError,
+		}
+		if !breaker.lastFailureAt.IsZero() {
+			providerHealth.LastFailureAt = &models.TimestampTime{Time: breaker.lastFailureAt}
+		}
+		if breaker.state != con.BREAKER_CLOSED {
+			providerHealth.OpenedAt = &models.TimestampTime{Time: breaker.openedAt}
+		}
+		health = append(health, providerHealth)
+	}
+	return health
+}
+
+// ResetBreakers closes every circuit breaker and forgets all provider statistics
+func ResetBreakers() {
+	breakersMu.Lock()
+	breakers = map[string]*circuitBreaker{}
+	breakersMu.Unlock()
+}
@@ -0,0 +1,119 @@
+package coin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"hermes-crypto-core/internal/models"
+)
+
+// CacheTTL is how long a fetched price is served from the cache before providers are asked again
+var CacheTTL = 5 * time.Second
+
+// VotePriceMaxAge is the oldest a cached price may be to be used for placing or resolving a vote
+var VotePriceMaxAge = 2 * time.Second
+
+// flight is a fetch shared by every caller waiting on the same coin and currency. Its context is
+// only cancelled once all of those callers have gone away.
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]models.CoinResult{}
+	flights = map[string]*flight{}
+	// requests collapses concurrent fetches of the same coin and currency into a single call
+	requests singleflight.Group
+)
+
+func cacheKey(coin models.Coin, currency string) string {
+	return coin.Id + "/" + currency
+}
+
+// GetCurrentExchangeRate returns the current price of the coin in the requested currency using
+// the configured price mode, served from the cache when it is younger than CacheTTL. If no currency
+// is requested the provider's own quote currency is used.
+func GetCurrentExchangeRate(ctx context.Context, coin models.Coin, currency string) (*models.CoinResult, error) {
+	return GetExchangeRateWithin(ctx, coin, currency, CacheTTL)
+}
+
+// GetExchangeRateWithin returns a price no older than maxAge, fetching a new one from the providers
+// if the cached price is too old. The age of the returned price is available through its Age method.
+func GetExchangeRateWithin(ctx context.Context, coin models.Coin, currency string, maxAge time.Duration) (*models.CoinResult, error) {
+	key := cacheKey(coin, currency)
+
+	cacheMu.Lock()
+	cached, ok := cache[key]
+	if ok && cached.Age() <= maxAge {
+		cacheMu.Unlock()
+		return &cached, nil
+	}
+	current := joinFlight(ctx, key)
+	cacheMu.Unlock()
+	defer leaveFlight(key, current)
+
+	results := requests.DoChan(key, func() (interface{}, error) {
+		result, err := fetchExchangeRate(current.ctx, coin, currency)
+		if err != nil {
+			return nil, err
+		}
+		cacheMu.Lock()
+		cache[key] = *result
+		cacheMu.Unlock()
+		return *result, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		// Every caller gets its own copy so the cached entry cannot be changed through it
+		coinResult := result.Val.(models.CoinResult)
+		return &coinResult, nil
+	}
+}
+
+// joinFlight registers a caller on the shared fetch for key, starting a new one if needed. The
+// fetch keeps the values of the first caller's context but not its cancellation. Callers hold cacheMu.
+func joinFlight(ctx context.Context, key string) *flight {
+	current, ok := flights[key]
+	if !ok {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		current = &flight{ctx: fetchCtx, cancel: cancel}
+		flights[key] = current
+	}
+	current.waiters++
+	return current
+}
+
+// leaveFlight removes a caller from the shared fetch, cancelling it when nobody is waiting anymore
+func leaveFlight(key string, current *flight) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	current.waiters--
+	if current.waiters == 0 {
+		current.cancel()
+		if flights[key] == current {
+			delete(flights, key)
+			// A caller arriving now must not join the fetch that was just cancelled
+			requests.Forget(key)
+		}
+	}
+}
+
+// ResetCache drops every cached price
+func ResetCache() {
+	cacheMu.Lock()
+	cache = map[string]models.CoinResult{}
+	cacheMu.Unlock()
+}
@@ -0,0 +1,93 @@
+package coin
+
+import (
+	"strings"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// SupportedCoins is the catalog of coins that can be predicted on, with the symbol each
+// price provider knows the coin by
+var SupportedCoins = []models.Coin{
+	{
+		Id: con.COIN_TYPE_BTC, Symbol: "BTC", Name: "Bitcoin", Decimals: 2,
+		Icon: "https://assets.coingecko.com/coins/images/1/large/bitcoin.png",
+		ProviderSymbols: map[string]string{
+			con.PROVIDER_BINANCE:  "BTCUSDT",
+			con.PROVIDER_KRAKEN:   "XBTUSD",
+			con.PROVIDER_COINBASE: "BTC-USD",
+			con.PROVIDER_GECKO:    con.COIN_TYPE_BTC,
+		},
+	},
+	{
+		Id: con.COIN_TYPE_ETH, Symbol: "ETH", Name: "Ethereum", Decimals: 2,
+		Icon: "https://assets.coingecko.com/coins/images/279/large/ethereum.png",
+		ProviderSymbols: map[string]string{
+			con.PROVIDER_BINANCE:  "ETHUSDT",
+			con.PROVIDER_KRAKEN:   "ETHUSD",
+			con.PROVIDER_COINBASE: "ETH-USD",
+			con.PROVIDER_GECKO:    con.COIN_TYPE_ETH,
+		},
+	},
+	{
+		Id: con.COIN_TYPE_SOL, Symbol: "SOL", Name: "Solana", Decimals: 2,
+		Icon: "https://assets.coingecko.com/coins/images/4128/large/solana.png",
+		ProviderSymbols: map[string]string{
+			con.PROVIDER_BINANCE:  "SOLUSDT",
+			con.PROVIDER_KRAKEN:   "SOLUSD",
+			con.PROVIDER_COINBASE: "SOL-USD",
+			con.PROVIDER_GECKO:    con.COIN_TYPE_SOL,
+		},
+	},
+	{
+		Id: con.COIN_TYPE_XRP, Symbol: "XRP", Name: "XRP", Decimals: 4,
+		Icon: "https://assets.coingecko.com/coins/images/44/large/xrp-symbol-white-128.png",
+		ProviderSymbols: map[string]string{
+			con.PROVIDER_BINANCE:  "XRPUSDT",
+			con.PROVIDER_KRAKEN:   "XRPUSD",
+			con.PROVIDER_COINBASE: "XRP-USD",
+			con.PROVIDER_GECKO:    con.COIN_TYPE_XRP,
+		},
+	},
+	{
+		Id: con.COIN_TYPE_ADA, Symbol: "ADA", Name: "Cardano", Decimals: 4,
+		Icon: "https://assets.coingecko.com/coins/images/975/large/cardano.png",
+		ProviderSymbols: map[string]string{
+			con.PROVIDER_BINANCE:  "ADAUSDT",
+			con.PROVIDER_KRAKEN:   "ADAUSD",
+			con.PROVIDER_COINBASE: "ADA-USD",
+			con.PROVIDER_GECKO:    con.COIN_TYPE_ADA,
+		},
+	},
+	{
+		Id: con.COIN_TYPE_DOGE, Symbol: "DOGE", Name: "Dogecoin", Decimals: 5,
+		Icon: "https://assets.coingecko.com/coins/images/5/large/dogecoin.png",
+		ProviderSymbols: map[string]string{
+			con.PROVIDER_BINANCE:  "DOGEUSDT",
+			con.PROVIDER_KRAKEN:   "XDGUSD",
+			con.PROVIDER_COINBASE: "DOGE-USD",
+			con.PROVIDER_GECKO:    con.COIN_TYPE_DOGE,
+		},
+	},
+}
+
+// GetSupportedCoin looks up a coin in the catalog by its id (bitcoin) or symbol (btc)
+func GetSupportedCoin(coin string) (*models.Coin, bool) {
+	coin = strings.TrimSpace(coin)
+	for i := range SupportedCoins {
+		if strings.EqualFold(SupportedCoins[i].Id, coin) || strings.EqualFold(SupportedCoins[i].Symbol, coin) {
+			return &SupportedCoins[i], true
+		}
+	}
+	return nil, false
+}
+
+// providerSymbol returns the symbol the given provider uses for the coin
+func providerSymbol(coin models.Coin, provider string) (string, error) {
+	symbol, ok := coin.ProviderSymbols[provider]
+	if !ok || symbol == "" {
+		return "", models.ReturnError{ErrorMessage: "Coin " + coin.Id + " is not supported by " + provider}
+	}
+	return symbol, nil
+}
@@ -0,0 +1,229 @@
+package coin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// DefaultBinanceStreamURL is the base URL of the Binance market data WebSocket API
+const DefaultBinanceStreamURL = "wss://stream.binance.com:9443"
+
+// StreamMaxAge is the oldest a streamed price may be before it is considered stale and the
+// REST providers are asked instead
+var StreamMaxAge = 10 * time.Second
+
+const (
+	streamMinBackoff  = time.Second
+	streamMaxBackoff  = 30 * time.Second
+	streamReadTimeout = time.Minute
+)
+
+// streamPrice is the last traded price of a symbol
+type streamPrice struct {
+	price     float64
+	tradeTime time.Time
+}
+
+// streamMessage is a message on a combined Binance stream
+type streamMessage struct {
+	Stream string `json:"stream"`
+	Data   struct {
+		Symbol    string `json:"s"`
+		Price     string `json:"p"`
+		TradeTime int64  `json:"T"`
+	} `json:"data"`
+}
+
+// BinanceStream keeps a WebSocket subscription to the Binance trade streams open and remembers the
+// last price per symbol. It is a PriceProvider, so it can sit in front of the REST providers.
+type BinanceStream struct {
+	baseURL string
+	symbols []string
+
+	mu     sync.RWMutex
+	prices map[string]streamPrice
+}
+
+// NewBinanceStream creates a stream for the given Binance symbols (e.g. BTCUSDT)
+func NewBinanceStream(baseURL string, symbols []string) *BinanceStream {
+	return &BinanceStream{baseURL: baseURL, symbols: symbols, prices: map[string]streamPrice{}}
+}
+
+// stream is the Binance stream Init put at the front of the provider chain, if any
+var stream *BinanceStream
+
+// configureStream puts a Binance stream for every supported coin at the front of the provider chain
+// when running as an HTTP server (HTTP_PORT is set), since Lambda can not keep one open. It is only
+// used when binance is one of the providers, and left out with BINANCE_STREAM=false. It is also left
+// out in consensus mode, where it would count Binance twice next to the REST provider.
+// BINANCE_STREAM_URL overrides its URL. The stream is only opened by StartStream.
+func configureStream() {
+	stream = nil
+	if os.Getenv("HTTP_PORT") == "" || os.Getenv("BINANCE_STREAM") == "false" || Mode == con.PRICE_MODE_CONSENSUS {
+		return
+	}
+	if !slices.ContainsFunc(Providers, func(provider PriceProvider) bool { return provider.Name() == con.PROVIDER_BINANCE }) {
+		return
+	}
+	baseURL := os.Getenv("BINANCE_STREAM_URL")
+	if baseURL == "" {
+		baseURL = DefaultBinanceStreamURL
+	}
+
+	var symbols []string
+	for _, coin := range SupportedCoins {
+		if symbol, err := providerSymbol(coin, con.PROVIDER_BINANCE); err == nil {
+			symbols = append(symbols, symbol)
+		}
+	}
+	stream = NewBinanceStream(baseURL, symbols)
+	Providers = append([]PriceProvider{stream}, Providers...)
+}
+
+// StartStream opens the Binance stream Init put in the provider chain and keeps it open until the
+// context is cancelled. It reports whether there was a stream to open; the chain is left as it is.
+func StartStream(ctx context.Context) bool {
+	if stream == nil {
+		return false
+	}
+	go stream.Run(ctx)
+	return true
+}
+
+func (s *BinanceStream) Name() string {
+	return con.PROVIDER_BINANCE_STREAM
+}
+
+func (s *BinanceStream) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USDT
+}
+
+// GetCurrentExchangeRate returns the last streamed price of the coin, as long as it is fresh
+func (s *BinanceStream) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	quote, err := s.GetCurrentQuote(ctx, coin)
+	if err != nil {
+		return nil, err
+	}
+	return &quote.Price, nil
+}
+
+// GetCurrentQuote returns the last streamed price of the coin and when it was traded
+func (s *BinanceStream) GetCurrentQuote(ctx context.Context, coin models.Coin) (*Quote, error) {
+	symbol, err := providerSymbol(coin, con.PROVIDER_BINANCE)
+	if err != nil {
+		return nil, err
+	}
+
+	price, tradeTime, ok := s.LastPrice(symbol)
+	if !ok {
+		return nil, models.ReturnError{ErrorMessage: "No streamed price for " + symbol}
+	}
+	if time.Since(tradeTime) > StreamMaxAge {
+		return nil, models.ReturnError{ErrorMessage: "Streamed price for " + symbol + " is stale"}
+	}
+	return &Quote{Price: price, Time: tradeTime}, nil
+}
+
+// LastPrice returns the last traded price of a symbol and when it was traded
+func (s *BinanceStream) LastPrice(symbol string) (float64, time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	last, ok := s.prices[strings.ToUpper(symbol)]
+	return last.price, last.tradeTime, ok
+}
+
+// Run connects to the stream and keeps reconnecting with exponential backoff until the context is cancelled
+func (s *BinanceStream) Run(ctx context.Context) {
+	backoff := streamMinBackoff
+	for {
+		connected, err := s.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = streamMinBackoff
+		}
+		log.Printf("Binance stream disconnected: %v, reconnecting in %v", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+func (s *BinanceStream) streamURL() string {
+	streams := make([]string, 0, len(s.symbols))
+	for _, symbol := range s.symbols {
+		streams = append(streams, strings.ToLower(symbol)+"@trade")
+	}
+	return s.baseURL + "/stream?streams=" + strings.Join(streams, "/")
+}
+
+// listen reads from a single connection until it fails, reporting whether it connected at all
+func (s *BinanceStream) listen(ctx context.Context) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.streamURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	log.Printf("Binance stream connected for %v", s.symbols)
+
+	// Close the connection when the context is cancelled so the read below returns
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+
+		var message streamMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			log.Printf("Could not parse Binance stream message: %v", err)
+			continue
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(message.Data.Price), 64)
+		if err != nil || message.Data.Symbol == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.prices[strings.ToUpper(message.Data.Symbol)] = streamPrice{
+			price:     price,
+			tradeTime: time.UnixMilli(message.Data.TradeTime),
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,81 @@
+package coin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// standInBinance is a local WebSocket server that behaves like the Binance combined stream. Every
+// connection is sent one trade per price in the prices channel and is then closed.
+func standInBinance(t *testing.T, prices chan float64) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("streams"); got != "btcusdt@trade" {
+			t.Errorf("got streams %q, want btcusdt@trade", got)
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		price := <-prices
+		message := fmt.Sprintf(`{"stream":"btcusdt@trade","data":{"e":"trade","s":"BTCUSDT","p":"%f","T":%d}}`, price, time.Now().UnixMilli())
+		conn.WriteMessage(websocket.TextMessage, []byte(message))
+		// Wait for the next price before dropping the connection, to force a reconnect
+		time.Sleep(50 * time.Millisecond)
+	}))
+}
+
+func waitForPrice(t *testing.T, stream *BinanceStream, want float64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if price, _, ok := stream.LastPrice("BTCUSDT"); ok && price == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	price, _, _ := stream.LastPrice("BTCUSDT")
+	t.Fatalf("got streamed price %v, want %v", price, want)
+}
+
+func TestBinanceStreamReceivesAndReconnects(t *testing.T) {
+	prices := make(chan float64, 2)
+	server := standInBinance(t, prices)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := NewBinanceStream("ws"+strings.TrimPrefix(server.URL, "http"), []string{"BTCUSDT"})
+	go stream.Run(ctx)
+
+	prices <- 60000
+	waitForPrice(t, stream, 60000)
+
+	// The stand-in closes the connection, so this price can only arrive after a reconnect
+	prices <- 61000
+	waitForPrice(t, stream, 61000)
+
+	bitcoin, _ := GetSupportedCoin("btc")
+	price, err := stream.GetCurrentExchangeRate(context.Background(), *bitcoin)
+	if err != nil || *price != 61000 {
+		t.Fatalf("got %v (%v), want 61000 from the stream", price, err)
+	}
+}
+
+func TestBinanceStreamStalePriceFallsThrough(t *testing.T) {
+	stream := NewBinanceStream("", []string{"BTCUSDT"})
+	stream.prices["BTCUSDT"] = streamPrice{price: 60000, tradeTime: time.Now().Add(-time.Hour)}
+
+	bitcoin, _ := GetSupportedCoin("btc")
+	if _, err := stream.GetCurrentExchangeRate(context.Background(), *bitcoin); err == nil {
+		t.Fatal("expected a stale streamed price to be rejected")
+	}
+}
@@ -3,37 +3,127 @@ package coin
 import (
 	"context"
 	"fmt"
-
-	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/adshao/go-binance/v2"
 
+	con "hermes-crypto-core/internal/constants"
 	"hermes-crypto-core/internal/models"
 )
 
-func BinanceGetCurrentExchangeRate() (*float64, error) {
-	apiKey := os.Getenv("BINANCE_API_KEY")
-	apiSecret := os.Getenv("BINANCE_SECRET_KEY")
-	client := binance.NewClient(apiKey, apiSecret)
+// BinanceProvider retrieves prices from the Binance API
+type BinanceProvider struct {
+	apiKey    string
+	apiSecret string
+}
 
-	prices, err := client.NewListPricesService().Symbol("BTCUSDT").Do(context.Background())
+// NewBinanceProvider creates a Binance price provider
+func NewBinanceProvider(cfg ProviderConfig) PriceProvider {
+	return &BinanceProvider{apiKey: cfg.APIKey, apiSecret: cfg.APISecret}
+}
+
+func (b *BinanceProvider) Name() string {
+	return con.PROVIDER_BINANCE
+}
+
+// QuoteCurrency is USDT, since all of the Binance symbols we use are USDT pairs
+func (b *BinanceProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USDT
+}
+
+func (b *BinanceProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	symbol, err := providerSymbol(coin, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	client := binance.NewClient(b.apiKey, b.apiSecret)
+
+	prices, err := client.NewListPricesService().Symbol(symbol).Do(ctx)
 	if err != nil {
 		fmt.Print("Something went wrong...")
 		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve data from Binance API"}
 	}
 
-	if len(prices) > 0 {
-		priceFloat, err := strconv.ParseFloat(strings.TrimSpace(prices[0].Price), 64)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse price: %w", err)
+	if len(prices) == 0 {
+		return nil, models.ReturnError{ErrorMessage: "No price data available from Binance API"}
+	}
+	priceFloat, err := strconv.ParseFloat(strings.TrimSpace(prices[0].Price), 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse price: %w", err)
+	}
+	fmt.Printf("BINANCE: Current %s price: $%.2f\n", symbol, priceFloat)
+	return &priceFloat, nil
+}
+
+// GetHistory retrieves klines from the Binance API
+func (b *BinanceProvider) GetHistory(ctx context.Context, coin models.Coin, interval string, from, to time.Time, limit int) ([]models.Candle, error) {
+	symbol, err := providerSymbol(coin, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	client := binance.NewClient(b.apiKey, b.apiSecret)
+
+	klines, err := client.NewKlinesService().Symbol(symbol).Interval(interval).
+		StartTime(from.UnixMilli()).EndTime(to.UnixMilli() - 1).Limit(limit).
+		Do(ctx)
+	if err != nil {
+		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve klines from Binance API"}
+	}
+
+	candles := make([]models.Candle, 0, len(klines))
+	for _, kline := range klines {
+		var values [5]float64
+		for i, value := range []string{kline.Open, kline.High, kline.Low, kline.Close, kline.Volume} {
+			values[i], err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse kline: %w", err)
+			}
 		}
-		fmt.Printf("BINANCE: Current BTC price: $%.2f\n", priceFloat)
-		return &priceFloat, nil
-	} else {
-		fmt.Println("No price data available")
-		zero := 0.0
-		return &zero, nil
+		candles = append(candles, models.Candle{
+			OpenTime:  models.TimestampTime{Time: time.UnixMilli(kline.OpenTime).UTC()},
+			CloseTime: models.TimestampTime{Time: time.UnixMilli(kline.CloseTime).UTC()},
+			Open:      values[0],
+			High:      values[1],
+			Low:       values[2],
+			Close:     values[3],
+			Volume:    values[4],
+		})
+	}
+	return candles, nil
+}
+
+// GetMarketSummary retrieves the 24 hour ticker statistics from the Binance API. Binance does not
+// report market capitalisation.
+func (b *BinanceProvider) GetMarketSummary(ctx context.Context, coin models.Coin) (*models.MarketSummary, error) {
+	symbol, err := providerSymbol(coin, b.Name())
+	if err != nil {
+		return nil, err
 	}
+	client := binance.NewClient(b.apiKey, b.apiSecret)
+
+	stats, err := client.NewListPriceChangeStatsService().Symbol(symbol).Do(ctx)
+	if err != nil {
+		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve 24hr ticker from Binance API"}
+	}
+	if len(stats) == 0 {
+		return nil, models.ReturnError{ErrorMessage: "No 24hr ticker available from Binance API"}
+	}
+
+	var values [5]float64
+	for i, value := range []string{stats[0].LastPrice, stats[0].HighPrice, stats[0].LowPrice, stats[0].QuoteVolume, stats[0].PriceChangePercent} {
+		if values[i], err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+			return nil, fmt.Errorf("failed to parse 24hr ticker: %w", err)
+		}
+	}
+
+	return &models.MarketSummary{
+		CoinValue:             values[0],
+		CoinValueCurrency:     b.QuoteCurrency(),
+		High24h:               &values[1],
+		Low24h:                &values[2],
+		Volume24h:             &values[3],
+		PriceChangePercent24h: &values[4],
+	}, nil
 }
@@ -0,0 +1,86 @@
+package coin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// DefaultCoinbaseURL is the base URL of the Coinbase Exchange REST API
+const DefaultCoinbaseURL = "https://api.exchange.coinbase.com"
+
+// CoinbaseProvider retrieves prices from the public Coinbase Exchange ticker
+type CoinbaseProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// coinbaseTicker is the response of the Coinbase Exchange product ticker endpoint
+type coinbaseTicker struct {
+	Price string `json:"price"`
+	Time  string `json:"time"`
+}
+
+// NewCoinbaseProvider creates a Coinbase Exchange price provider
+func NewCoinbaseProvider(cfg ProviderConfig) PriceProvider {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultCoinbaseURL
+	}
+	return &CoinbaseProvider{baseURL: strings.TrimRight(baseURL, "/"), client: httpClientOrDefault(cfg.HTTPClient)}
+}
+
+func (cb *CoinbaseProvider) Name() string {
+	return con.PROVIDER_COINBASE
+}
+
+func (cb *CoinbaseProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USD
+}
+
+func (cb *CoinbaseProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	quote, err := cb.GetCurrentQuote(ctx, coin)
+	if err != nil {
+		return nil, err
+	}
+	return &quote.Price, nil
+}
+
+// GetCurrentQuote returns the last traded price of the coin and when it was traded
+func (cb *CoinbaseProvider) GetCurrentQuote(ctx context.Context, coin models.Coin) (*Quote, error) {
+	product, err := providerSymbol(coin, cb.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	var ticker coinbaseTicker
+	if err := getJSON(ctx, cb.client, cb.baseURL+"/products/"+url.PathEscape(product)+"/ticker", &ticker); err != nil {
+		log.Printf("Coinbase request failed: %v", err)
+		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve data from Coinbase API"}
+	}
+	if ticker.Price == "" {
+		return nil, models.ReturnError{ErrorMessage: "No price data available from Coinbase API"}
+	}
+
+	price, err := strconv.ParseFloat(strings.TrimSpace(ticker.Price), 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse price: %w", err)
+	}
+
+	// The trade time is optional, without it the price is taken to be current
+	tradeTime := time.Now()
+	if ticker.Time != "" {
+		if parsed, err := time.Parse(time.RFC3339Nano, ticker.Time); err == nil {
+			tradeTime = parsed
+		}
+	}
+	return &Quote{Price: price, Time: tradeTime}, nil
+}
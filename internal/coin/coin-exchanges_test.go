@@ -0,0 +1,87 @@
+package coin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	con "hermes-crypto-core/internal/constants"
+)
+
+// standInExchange is a local HTTP server that answers a single path with a canned JSON body
+func standInExchange(t *testing.T, path string, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != path {
+			t.Errorf("got request %q, want %q", r.URL.RequestURI(), path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestKrakenProvider(t *testing.T) {
+	btc, _ := GetSupportedCoin(con.COIN_TYPE_BTC)
+	server := standInExchange(t, "/0/public/Ticker?pair=XBTUSD", http.StatusOK,
+		`{"error":[],"result":{"XXBTZUSD":{"a":["64010.1","1","1.000"],"c":["64000.5","0.01"]}}}`)
+
+	provider := NewKrakenProvider(ProviderConfig{BaseURL: server.URL, HTTPClient: server.Client()})
+	price, err := provider.GetCurrentExchangeRate(context.Background(), *btc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *price != 64000.5 {
+		t.Errorf("got price %v, want 64000.5", *price)
+	}
+	if provider.QuoteCurrency() != con.COIN_CURRENCY_USD {
+		t.Errorf("got quote currency %s, want %s", provider.QuoteCurrency(), con.COIN_CURRENCY_USD)
+	}
+}
+
+func TestKrakenProviderError(t *testing.T) {
+	btc, _ := GetSupportedCoin(con.COIN_TYPE_BTC)
+	server := standInExchange(t, "/0/public/Ticker?pair=XBTUSD", http.StatusOK,
+		`{"error":["EQuery:Unknown asset pair"],"result":{}}`)
+
+	provider := NewKrakenProvider(ProviderConfig{BaseURL: server.URL, HTTPClient: server.Client()})
+	if _, err := provider.GetCurrentExchangeRate(context.Background(), *btc); err == nil {
+		t.Fatal("expected an error for a Kraken API error response")
+	}
+}
+
+func TestCoinbaseProvider(t *testing.T) {
+	eth, _ := GetSupportedCoin(con.COIN_TYPE_ETH)
+	server := standInExchange(t, "/products/ETH-USD/ticker", http.StatusOK,
+		`{"trade_id":1,"price":"3120.45","size":"0.1","time":"2024-01-01T00:00:00Z"}`)
+
+	provider := NewCoinbaseProvider(ProviderConfig{BaseURL: server.URL, HTTPClient: server.Client()})
+	price, err := provider.GetCurrentExchangeRate(context.Background(), *eth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *price != 3120.45 {
+		t.Errorf("got price %v, want 3120.45", *price)
+	}
+}
+
+func TestCoinbaseProviderFailureFallsBack(t *testing.T) {
+	btc, _ := GetSupportedCoin(con.COIN_TYPE_BTC)
+	coinbase := standInExchange(t, "/products/BTC-USD/ticker", http.StatusNotFound, `{"message":"NotFound"}`)
+	kraken := standInExchange(t, "/0/public/Ticker?pair=XBTUSD", http.StatusOK,
+		`{"error":[],"result":{"XXBTZUSD":{"c":["64000.5","0.01"]}}}`)
+
+	useProviders(
+		NewCoinbaseProvider(ProviderConfig{BaseURL: coinbase.URL, HTTPClient: coinbase.Client()}),
+		NewKrakenProvider(ProviderConfig{BaseURL: kraken.URL, HTTPClient: kraken.Client()}),
+	)
+	result, err := GetCurrentExchangeRate(context.Background(), *btc, con.COIN_CURRENCY_USD)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Provider != con.PROVIDER_KRAKEN || result.CoinValue != 64000.5 {
+		t.Errorf("got %s %v, want kraken 64000.5", result.Provider, result.CoinValue)
+	}
+}
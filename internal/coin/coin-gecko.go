@@ -1,8 +1,12 @@
 package coin
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/JulianToledano/goingecko"
 
@@ -10,18 +14,98 @@ import (
 	"hermes-crypto-core/internal/models"
 )
 
-// CoinGeckoService is a service that interacts with the CoinGecko API
-func GeckoGetCurrentExchangeRate() (*float64, error) {
-	apiKey := os.Getenv("GECKO_API_KEY")
-	cgClient := goingecko.NewClient(nil, apiKey)
+// GeckoProvider retrieves prices from the CoinGecko API
+type GeckoProvider struct {
+	apiKey string
+}
+
+// NewGeckoProvider creates a CoinGecko price provider
+func NewGeckoProvider(cfg ProviderConfig) PriceProvider {
+	return &GeckoProvider{apiKey: cfg.APIKey}
+}
+
+func (g *GeckoProvider) Name() string {
+	return con.PROVIDER_GECKO
+}
+
+func (g *GeckoProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USD
+}
+
+// GetCurrentExchangeRate interacts with the CoinGecko API to get the current price
+func (g *GeckoProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	id, err := providerSymbol(coin, g.Name())
+	if err != nil {
+		return nil, err
+	}
+	cgClient := goingecko.NewClient(contextHTTPClient(ctx), g.apiKey)
 	defer cgClient.Close()
 
-	data, err := cgClient.CoinsId(con.COIN_TYPE_BTC, true, true, true, false, false, false)
+	data, err := cgClient.CoinsId(id, true, true, true, false, false, false)
 	if err != nil {
 		fmt.Print("Something went wrong...")
 		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve data from CoinGecko API"}
 	}
-	fmt.Printf("GECKO: Bitcoin price is: %f$", data.MarketData.CurrentPrice.Usd)
+	fmt.Printf("GECKO: %s price is: %f$", id, data.MarketData.CurrentPrice.Usd)
 
 	return &data.MarketData.CurrentPrice.Usd, nil
 }
+
+// GetMarketSummary retrieves the 24 hour market data from the CoinGecko API
+func (g *GeckoProvider) GetMarketSummary(ctx context.Context, coin models.Coin) (*models.MarketSummary, error) {
+	id, err := providerSymbol(coin, g.Name())
+	if err != nil {
+		return nil, err
+	}
+	cgClient := goingecko.NewClient(contextHTTPClient(ctx), g.apiKey)
+	defer cgClient.Close()
+
+	data, err := cgClient.CoinsId(id, false, false, true, false, false, false)
+	if err != nil {
+		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve market data from CoinGecko API"}
+	}
+	marketData := data.MarketData
+
+	return &models.MarketSummary{
+		CoinValue:             marketData.CurrentPrice.Usd,
+		CoinValueCurrency:     g.QuoteCurrency(),
+		High24h:               &marketData.High24.Usd,
+		Low24h:                &marketData.Low24.Usd,
+		Volume24h:             &marketData.TotalVolume.Usd,
+		PriceChangePercent24h: &marketData.PriceChangePercentDay,
+		MarketCap:             &marketData.MarketCap.Usd,
+	}, nil
+}
+
+// GetHistory builds candles from the CoinGecko market chart. CoinGecko only returns prices (no
+// OHLC) at a granularity that depends on the range, so candles can be sparse for short intervals.
+func (g *GeckoProvider) GetHistory(ctx context.Context, coin models.Coin, interval string, from, to time.Time, limit int) ([]models.Candle, error) {
+	id, err := providerSymbol(coin, g.Name())
+	if err != nil {
+		return nil, err
+	}
+	cgClient := goingecko.NewClient(contextHTTPClient(ctx), g.apiKey)
+	defer cgClient.Close()
+
+	chart, err := cgClient.CoinsIdMarketChartRange(id, strings.ToLower(g.QuoteCurrency()),
+		strconv.FormatInt(from.Unix(), 10), strconv.FormatInt(to.Unix(), 10))
+	if err != nil {
+		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve market chart from CoinGecko API"}
+	}
+
+	return candlesFromPrices(chart.Prices, HistoryIntervals[interval], from, to, limit), nil
+}
+
+// contextTransport attaches a context to every request, since the CoinGecko client does not take one
+type contextTransport struct {
+	ctx context.Context
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(req.WithContext(t.ctx))
+}
+
+// contextHTTPClient returns an HTTP client whose requests are cancelled along with the context
+func contextHTTPClient(ctx context.Context) *http.Client {
+	return &http.Client{Transport: contextTransport{ctx: ctx}}
+}
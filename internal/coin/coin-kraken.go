@@ -0,0 +1,78 @@
+package coin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// DefaultKrakenURL is the base URL of the Kraken REST API
+const DefaultKrakenURL = "https://api.kraken.com"
+
+// KrakenProvider retrieves prices from the public Kraken ticker
+type KrakenProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// krakenTicker is the response of the Kraken ticker endpoint
+type krakenTicker struct {
+	Error  []string `json:"error"`
+	Result map[string]struct {
+		LastTrade []string `json:"c"` // [price, lot volume]
+	} `json:"result"`
+}
+
+// NewKrakenProvider creates a Kraken price provider
+func NewKrakenProvider(cfg ProviderConfig) PriceProvider {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultKrakenURL
+	}
+	return &KrakenProvider{baseURL: strings.TrimRight(baseURL, "/"), client: httpClientOrDefault(cfg.HTTPClient)}
+}
+
+func (k *KrakenProvider) Name() string {
+	return con.PROVIDER_KRAKEN
+}
+
+func (k *KrakenProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USD
+}
+
+func (k *KrakenProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	pair, err := providerSymbol(coin, k.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	var ticker krakenTicker
+	if err := getJSON(ctx, k.client, k.baseURL+"/0/public/Ticker?pair="+url.QueryEscape(pair), &ticker); err != nil {
+		log.Printf("Kraken request failed: %v", err)
+		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve data from Kraken API"}
+	}
+	if len(ticker.Error) > 0 {
+		return nil, models.ReturnError{ErrorMessage: "Kraken API error: " + strings.Join(ticker.Error, ", ")}
+	}
+
+	// Kraken answers with its own name for the pair (XBTUSD becomes XXBTZUSD), so take the only result
+	for _, result := range ticker.Result {
+		if len(result.LastTrade) == 0 {
+			break
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(result.LastTrade[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse price: %w", err)
+		}
+		return &price, nil
+	}
+
+	return nil, models.ReturnError{ErrorMessage: "No price data available from Kraken API"}
+}
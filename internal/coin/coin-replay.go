@@ -0,0 +1,368 @@
+package coin
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// replayStartPrices are the prices random walks start from, in USD
+var replayStartPrices = map[string]float64{
+	con.COIN_TYPE_BTC:  60000,
+	con.COIN_TYPE_ETH:  3000,
+	con.COIN_TYPE_SOL:  150,
+	con.COIN_TYPE_XRP:  0.5,
+	con.COIN_TYPE_ADA:  0.35,
+	con.COIN_TYPE_DOGE: 0.12,
+}
+
+// replayFXRates are the fixed FX rates used when every price is replayed
+var replayFXRates = StaticFXSource{
+	con.COIN_CURRENCY_USD:  1,
+	con.COIN_CURRENCY_USDT: 1,
+	con.COIN_CURRENCY_EUR:  1.08,
+	con.COIN_CURRENCY_GBP:  1.27,
+	con.COIN_CURRENCY_ZAR:  0.055,
+}
+
+// ReplayConfig configures a replay provider. Without a file, prices follow a random walk.
+type ReplayConfig struct {
+	File       string           // CSV or NDJSON file of ticks to replay
+	Seed       int64            // Seed of the random walk
+	Interval   time.Duration    // Time between two steps of the random walk
+	Volatility float64          // Standard deviation of the relative price change per step
+	Now        func() time.Time // Clock of the replay, time.Now if nil
+}
+
+// replayTick is a single price of a coin in a tick file
+type replayTick struct {
+	Time  string
+	Coin  string
+	Price float64
+}
+
+// replaySeries is the replayed price of a single coin; offsets are relative to the start of the replay
+type replaySeries struct {
+	offsets []time.Duration
+	prices  []float64
+	rng     *rand.Rand // Only set for random walks, which are extended as time passes
+}
+
+// ReplayProvider replays prices from a tick file, or generates them with a seeded random walk, so
+// that the game can run offline with reproducible prices. The replay starts when the provider is
+// created; tick files are looped once they run out.
+type ReplayProvider struct {
+	cfg    ReplayConfig
+	start  time.Time
+	period time.Duration // Length of one loop of the tick file
+
+	mu     sync.Mutex
+	series map[string]*replaySeries
+}
+
+// NewReplay creates a replay provider, reading the tick file if one is configured
+func NewReplay(cfg ReplayConfig) (*ReplayProvider, error) {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Volatility <= 0 {
+		cfg.Volatility = 0.001
+	}
+	r := &ReplayProvider{cfg: cfg, start: cfg.Now(), series: map[string]*replaySeries{}}
+
+	if cfg.File == "" {
+		return r, nil
+	}
+	if err := r.load(cfg.File); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewReplayProvider creates a replay provider configured by REPLAY_FILE, REPLAY_SEED,
+// REPLAY_INTERVAL and REPLAY_VOLATILITY. A tick file that can not be read is fatal, since
+// silently falling back to random prices would defeat the point of replaying it.
+func NewReplayProvider(cfg ProviderConfig) PriceProvider {
+	replayConfig := ReplayConfig{File: os.Getenv("REPLAY_FILE"), Seed: 1}
+	if seed, err := strconv.ParseInt(os.Getenv("REPLAY_SEED"), 10, 64); err == nil {
+		replayConfig.Seed = seed
+	}
+	if interval, err := time.ParseDuration(os.Getenv("REPLAY_INTERVAL")); err == nil && interval > 0 {
+		replayConfig.Interval = interval
+	}
+	if volatility, err := strconv.ParseFloat(os.Getenv("REPLAY_VOLATILITY"), 64); err == nil && volatility > 0 {
+		replayConfig.Volatility = volatility
+	}
+
+	provider, err := NewReplay(replayConfig)
+	if err != nil {
+		log.Fatalf("Unable to load replay file %s: %v", replayConfig.File, err)
+	}
+	if replayConfig.File != "" {
+		log.Printf("Replaying prices from %s", replayConfig.File)
+	} else {
+		log.Printf("Replaying a random walk with seed %d", replayConfig.Seed)
+	}
+	return provider
+}
+
+func (r *ReplayProvider) Name() string {
+	return con.PROVIDER_REPLAY
+}
+
+func (r *ReplayProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USD
+}
+
+// GetCurrentExchangeRate returns the replayed price of the coin at the current time
+func (r *ReplayProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	price, ok := r.priceAt(coin.Id, r.cfg.Now().Sub(r.start))
+	if !ok {
+		return nil, models.ReturnError{ErrorMessage: "No replayed price for " + coin.Id}
+	}
+	return &price, nil
+}
+
+// GetHistory builds candles from the prices replayed so far
+func (r *ReplayProvider) GetHistory(ctx context.Context, coin models.Coin, interval string, from, to time.Time, limit int) ([]models.Candle, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.cfg.Now()
+	if to.After(now) {
+		to = now
+	}
+
+	var points [][]float64
+	for _, at := range r.tickTimes(coin.Id, from, to) {
+		if price, ok := r.priceAt(coin.Id, at.Sub(r.start)); ok {
+			points = append(points, []float64{float64(at.UnixMilli()), price})
+		}
+	}
+	return candlesFromPrices(points, HistoryIntervals[interval], from, to, limit), nil
+}
+
+// priceAt returns the price of a coin at the given time since the start of the replay. Callers hold mu.
+func (r *ReplayProvider) priceAt(coinId string, position time.Duration) (float64, bool) {
+	if position < 0 {
+		return 0, false
+	}
+	if r.cfg.File == "" {
+		return r.walk(coinId, int(position/r.cfg.Interval)), true
+	}
+
+	series, ok := r.series[coinId]
+	if !ok {
+		return 0, false
+	}
+	if r.period > 0 {
+		position %= r.period
+	}
+	// The last tick at or before the position
+	i := sort.Search(len(series.offsets), func(i int) bool { return series.offsets[i] > position })
+	return series.prices[max(i-1, 0)], true
+}
+
+// tickTimes returns the times between from and to at which the price of a coin changes. Callers hold mu.
+func (r *ReplayProvider) tickTimes(coinId string, from, to time.Time) []time.Time {
+	if from.Before(r.start) {
+		from = r.start
+	}
+
+	var times []time.Time
+	if r.cfg.File == "" {
+		step := (from.Sub(r.start) + r.cfg.Interval - 1) / r.cfg.Interval
+		for at := r.start.Add(step * r.cfg.Interval); at.Before(to); at = at.Add(r.cfg.Interval) {
+			times = append(times, at)
+		}
+		return times
+	}
+
+	series, ok := r.series[coinId]
+	if !ok {
+		return nil
+	}
+	if r.period == 0 {
+		return []time.Time{r.start}
+	}
+	for loop := r.start.Add(from.Sub(r.start) / r.period * r.period); loop.Before(to); loop = loop.Add(r.period) {
+		for _, offset := range series.offsets {
+			if at := loop.Add(offset); !at.Before(from) && at.Before(to) {
+				times = append(times, at)
+			}
+		}
+	}
+	return times
+}
+
+// walk returns the price of a coin after the given number of random walk steps. Callers hold mu.
+func (r *ReplayProvider) walk(coinId string, step int) float64 {
+	series, ok := r.series[coinId]
+	if !ok {
+		// Every coin gets its own generator, so the walk of one coin does not depend on the others
+		hash := fnv.New64a()
+		hash.Write([]byte(coinId))
+		startPrice, ok := replayStartPrices[coinId]
+		if !ok {
+			startPrice = 1
+		}
+		series = &replaySeries{prices: []float64{startPrice}, rng: rand.New(rand.NewSource(r.cfg.Seed ^ int64(hash.Sum64())))}
+		r.series[coinId] = series
+	}
+
+	for len(series.prices) <= step {
+		last := series.prices[len(series.prices)-1]
+		series.prices = append(series.prices, last*math.Exp(r.cfg.Volatility*series.rng.NormFloat64()))
+	}
+	return series.prices[step]
+}
+
+// isReplayOnly reports whether every provider in the chain replays prices
+func isReplayOnly(providers []PriceProvider) bool {
+	for _, provider := range providers {
+		if provider.Name() != con.PROVIDER_REPLAY {
+			return false
+		}
+	}
+	return len(providers) > 0
+}
+
+// load reads a tick file; .csv files have time, coin and price columns, any other file is read as NDJSON
+func (r *ReplayProvider) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var ticks []replayTick
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		ticks, err = readCSVTicks(file)
+	} else {
+		ticks, err = readNDJSONTicks(file)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ticks) == 0 {
+		return models.ReturnError{ErrorMessage: "Replay file has no ticks"}
+	}
+
+	type timedTick struct {
+		at    time.Time
+		coin  string
+		price float64
+	}
+	timed := make([]timedTick, 0, len(ticks))
+	for _, tick := range ticks {
+		at, err := parseTickTime(tick.Time)
+		if err != nil {
+			return err
+		}
+		coin, ok := GetSupportedCoin(tick.Coin)
+		if !ok {
+			return models.ReturnError{ErrorMessage: "Replay file has an unsupported coin: " + tick.Coin}
+		}
+		timed = append(timed, timedTick{at: at, coin: coin.Id, price: tick.Price})
+	}
+	sort.SliceStable(timed, func(i, j int) bool { return timed[i].at.Before(timed[j].at) })
+
+	first := timed[0].at
+	for _, tick := range timed {
+		series, ok := r.series[tick.coin]
+		if !ok {
+			series = &replaySeries{}
+			r.series[tick.coin] = series
+		}
+		series.offsets = append(series.offsets, tick.at.Sub(first))
+		series.prices = append(series.prices, tick.price)
+	}
+	// A loop lasts until the last tick has been shown as long as the one before it
+	last := timed[len(timed)-1].at
+	for i := len(timed) - 2; i >= 0; i-- {
+		if gap := last.Sub(timed[i].at); gap > 0 {
+			r.period = last.Sub(first) + gap
+			break
+		}
+	}
+	return nil
+}
+
+func readCSVTicks(reader io.Reader) ([]replayTick, error) {
+	rows, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var ticks []replayTick
+	for i, row := range rows {
+		if len(row) < 3 {
+			return nil, models.ReturnError{ErrorMessage: "Replay file row " + strconv.Itoa(i+1) + " needs time, coin and price"}
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		if err != nil {
+			// A header row
+			if i == 0 {
+				continue
+			}
+			return nil, err
+		}
+		ticks = append(ticks, replayTick{Time: strings.TrimSpace(row[0]), Coin: strings.TrimSpace(row[1]), Price: price})
+	}
+	return ticks, nil
+}
+
+func readNDJSONTicks(reader io.Reader) ([]replayTick, error) {
+	var ticks []replayTick
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// Times may be strings or unix timestamps
+		var tick struct {
+			Time  json.RawMessage `json:"time"`
+			Coin  string          `json:"coin"`
+			Price float64         `json:"price"`
+		}
+		if err := json.Unmarshal([]byte(line), &tick); err != nil {
+			return nil, err
+		}
+		ticks = append(ticks, replayTick{Time: strings.Trim(string(tick.Time), `"`), Coin: tick.Coin, Price: tick.Price})
+	}
+	return ticks, scanner.Err()
+}
+
+// parseTickTime accepts RFC3339 times and unix timestamps in seconds or milliseconds
+func parseTickTime(value string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if unix > 1e12 {
+			return time.UnixMilli(unix), nil
+		}
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -1,19 +1,212 @@
 package coin
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
 	"hermes-crypto-core/internal/models"
 )
 
-func GetCurrentExchangeRate() (*float64, error) {
-	currentExchangeRate, err := BinanceGetCurrentExchangeRate()
-	if err != nil {
-		geckoExchangeRate, err := GeckoGetCurrentExchangeRate()
+// PriceProvider is a third-party source of coin prices
+type PriceProvider interface {
+	// Name returns the identifier of the provider as used in configuration
+	Name() string
+	// QuoteCurrency returns the currency (or stablecoin) the provider quotes its prices in
+	QuoteCurrency() string
+	// GetCurrentExchangeRate returns the current price of the given coin in the quote currency
+	GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error)
+}
+
+// ProviderConfig holds the configuration (credentials and endpoint) for a single provider
+type ProviderConfig struct {
+	Name       string
+	APIKey     string
+	APISecret  string
+	BaseURL    string       // Overrides the provider's default API URL, e.g. to point at a local stand-in (Kraken and Coinbase only)
+	HTTPClient *http.Client // Client used for requests, the default client if nil
+}
+
+// ProviderFactory builds a PriceProvider from its configuration
+type ProviderFactory func(cfg ProviderConfig) PriceProvider
+
+// registry holds all known providers by name, whether they are enabled or not
+var registry = map[string]ProviderFactory{
+	con.PROVIDER_BINANCE:  NewBinanceProvider,
+	con.PROVIDER_GECKO:    NewGeckoProvider,
+	con.PROVIDER_KRAKEN:   NewKrakenProvider,
+	con.PROVIDER_COINBASE: NewCoinbaseProvider,
+	con.PROVIDER_REPLAY:   NewReplayProvider,
+}
+
+// Providers is the ordered fallback chain of enabled providers
+var Providers []PriceProvider
+
+// Mode decides how prices are determined: by falling back through the chain or by consensus
+var Mode = con.PRICE_MODE_FALLBACK
+
+// RegisterProvider adds (or replaces) a provider factory in the registry
+func RegisterProvider(name string, factory ProviderFactory) {
+	registry[name] = factory
+}
+
+// Init builds the provider chain from the environment. PRICE_PROVIDERS is a comma separated
+// list of provider names in the order they should be tried; credentials for each provider
+// are read from <NAME>_API_KEY and <NAME>_SECRET_KEY, and <NAME>_BASE_URL overrides the API URL
+// of the Kraken and Coinbase providers. PRICE_MODE selects fallback or consensus pricing, tuned by PRICE_CONSENSUS_TIMEOUT and
+// PRICE_OUTLIER_THRESHOLD, and PRICE_CACHE_TTL sets how long fetched prices are reused.
+// PROVIDER_FAILURE_THRESHOLD and PROVIDER_COOLDOWN tune the circuit breakers that skip unhealthy
+// providers, and PROVIDER_TIMEOUT bounds every provider call. PRICE_MAX_AGE, PRICE_MAX_DEVIATION
+// and PRICE_REFERENCE_MAX_AGE tune the validation that rejects stale or implausible quotes, and
+// PRICE_FEED_INTERVAL sets how often streamed prices are refreshed. SUMMARY_CACHE_TTL sets how long
+// market summaries are reused. <NAME>_MONTHLY_QUOTA and <NAME>_QUOTA_RESERVE meter provider calls.
+// BINANCE_STREAM and BINANCE_STREAM_URL configure the live stream in front of the chain.
+func Init() {
+	names := os.Getenv("PRICE_PROVIDERS")
+	if names == "" {
+		names = con.PROVIDER_BINANCE + "," + con.PROVIDER_GECKO
+	}
+
+	Providers = nil
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		factory, ok := registry[name]
+		if !ok {
+			log.Printf("Unknown price provider %q, skipping", name)
+			continue
+		}
+		Providers = append(Providers, factory(loadProviderConfig(name)))
+	}
+
+	// FX rates are derived from CoinGecko, which quotes in every currency we support, unless every
+	// price is replayed; then the game runs offline with fixed rates
+	if isReplayOnly(Providers) {
+		FX = replayFXRates
+	} else {
+		FX = NewGeckoFXSource(loadProviderConfig(con.PROVIDER_GECKO).APIKey)
+	}
+
+	if mode := strings.ToLower(os.Getenv("PRICE_MODE")); mode == con.PRICE_MODE_CONSENSUS {
+		Mode = con.PRICE_MODE_CONSENSUS
+	} else {
+		Mode = con.PRICE_MODE_FALLBACK
+	}
+	configureStream()
+	log.Printf("Price providers enabled: %v", providerNames(Providers))
+	if timeout, err := time.ParseDuration(os.Getenv("PRICE_CONSENSUS_TIMEOUT")); err == nil && timeout > 0 {
+		ConsensusTimeout = timeout
+	}
+	if threshold, err := strconv.ParseFloat(os.Getenv("PRICE_OUTLIER_THRESHOLD"), 64); err == nil && threshold > 0 {
+		OutlierThreshold = threshold
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("PRICE_CACHE_TTL")); err == nil && ttl >= 0 {
+		CacheTTL = ttl
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("PROVIDER_FAILURE_THRESHOLD")); err == nil && threshold > 0 {
+		FailureThreshold = threshold
+	}
+	if cooldown, err := time.ParseDuration(os.Getenv("PROVIDER_COOLDOWN")); err == nil && cooldown > 0 {
+		BreakerCooldown = cooldown
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("PROVIDER_TIMEOUT")); err == nil && timeout > 0 {
+		ProviderTimeout = timeout
+	}
+	if maxAge, err := time.ParseDuration(os.Getenv("PRICE_MAX_AGE")); err == nil && maxAge > 0 {
+		QuoteMaxAge = maxAge
+	}
+	if deviation, err := strconv.ParseFloat(os.Getenv("PRICE_MAX_DEVIATION"), 64); err == nil && deviation > 0 {
+		QuoteMaxDeviation = deviation
+	}
+	if maxAge, err := time.ParseDuration(os.Getenv("PRICE_REFERENCE_MAX_AGE")); err == nil && maxAge > 0 {
+		QuoteReferenceMaxAge = maxAge
+	}
+	if interval, err := time.ParseDuration(os.Getenv("PRICE_FEED_INTERVAL")); err == nil && interval > 0 {
+		FeedInterval = interval
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("SUMMARY_CACHE_TTL")); err == nil && ttl >= 0 {
+		SummaryCacheTTL = ttl
+	}
+	ResetCache()
+	ResetBreakers()
+	ResetQuotes()
+	ResetSummaries()
+	configureQuotas()
+	log.Printf("Price mode: %s", Mode)
+}
+
+func loadProviderConfig(name string) ProviderConfig {
+	prefix := strings.ToUpper(name)
+	return ProviderConfig{
+		Name:      name,
+		APIKey:    os.Getenv(prefix + "_API_KEY"),
+		APISecret: os.Getenv(prefix + "_SECRET_KEY"),
+		BaseURL:   os.Getenv(prefix + "_BASE_URL"),
+	}
+}
+
+func providerNames(providers []PriceProvider) []string {
+	names := make([]string, 0, len(providers))
+	for _, p := range providers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// fetchExchangeRate asks the providers for the current price of the coin using the configured price mode
+func fetchExchangeRate(ctx context.Context, coin models.Coin, currency string) (*models.CoinResult, error) {
+	if Mode == con.PRICE_MODE_CONSENSUS {
+		return GetConsensusExchangeRate(ctx, coin, currency)
+	}
+	return getFallbackExchangeRate(ctx, coin, currency)
+}
+
+// getFallbackExchangeRate walks the provider chain in order and returns the first valid price
+// found for the coin, along with the name of the provider that answered
+func getFallbackExchangeRate(ctx context.Context, coin models.Coin, currency string) (*models.CoinResult, error) {
+	for i, provider := range Providers {
+		// Providers after the first are only asked because the ones before them could not answer
+		callCtx := ctx
+		if i > 0 {
+			callCtx = withFallback(ctx)
+		}
+		quote, err := fetchQuote(callCtx, provider, coin)
+		// The caller has gone away, so there is no point in asking the next provider
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err != nil {
-			return nil, models.ReturnError{ErrorMessage: "Could not determine current exchange rate"}
+			log.Printf("Price provider %s failed: %v", provider.Name(), err)
+			continue
+		}
+
+		rate := quote
+		quoteCurrency := provider.QuoteCurrency()
+		if currency != "" && currency != quoteCurrency {
+			rate, err = Convert(ctx, *rate, quoteCurrency, currency)
+			if err != nil {
+				log.Printf("Could not convert %s price from %s to %s: %v", provider.Name(), quoteCurrency, currency, err)
+				continue
+			}
+			quoteCurrency = currency
 		}
+		rememberQuote(provider, coin, *quote)
 
-		return geckoExchangeRate, nil
+		return &models.CoinResult{
+			Coin:              coin.Id,
+			CoinValue:         *rate,
+			CoinValueCurrency: quoteCurrency,
+			Provider:          provider.Name(),
+			QueryTime:         models.TimestampTime{Time: time.Now()},
+		}, nil
 	}
 
-	return currentExchangeRate, nil
+	return nil, models.ReturnError{ErrorMessage: "Could not determine current exchange rate"}
 }
@@ -0,0 +1,890 @@
+package coin
+
+import (
+	"context"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/models"
+)
+
+// stubProvider is a PriceProvider returning a fixed price or error
+type stubProvider struct {
+	name  string
+	price float64
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (s *stubProvider) Name() string {
+	return s.name
+}
+
+func (s *stubProvider) QuoteCurrency() string {
+	return "USDT"
+}
+
+func (s *stubProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	s.calls++
+	time.Sleep(s.delay)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &s.price, nil
+}
+
+// useProviders replaces the provider chain, clearing any cached prices and provider health
+func useProviders(providers ...PriceProvider) {
+	Providers = providers
+	ResetCache()
+	ResetBreakers()
+	ResetQuotes()
+	ResetSummaries()
+}
+
+func TestFallbackExchangeRateFallsBack(t *testing.T) {
+	failing := &stubProvider{name: "first", err: models.ReturnError{ErrorMessage: "down"}}
+	working := &stubProvider{name: "second", price: 42}
+	unused := &stubProvider{name: "third", price: 7}
+	useProviders(failing, working, unused)
+
+	bitcoin, _ := GetSupportedCoin("btc")
+	result, err := getFallbackExchangeRate(context.Background(), *bitcoin, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.CoinValue != 42 || result.Provider != "second" {
+		t.Fatalf("got %v from %s, want 42 from second", result.CoinValue, result.Provider)
+	}
+	if unused.calls != 0 {
+		t.Fatalf("expected third provider not to be called, got %d calls", unused.calls)
+	}
+}
+
+func TestFallbackExchangeRateAllFail(t *testing.T) {
+	useProviders(&stubProvider{name: "first", err: models.ReturnError{ErrorMessage: "down"}})
+
+	if _, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], ""); err == nil {
+		t.Fatal("expected an error when every provider fails")
+	}
+}
+
+func TestInitUsesConfiguredOrder(t *testing.T) {
+	t.Setenv("PRICE_PROVIDERS", "gecko, unknown ,binance")
+	t.Setenv("BINANCE_API_KEY", "key")
+	Init()
+
+	if got := providerNames(Providers); len(got) != 2 || got[0] != "gecko" || got[1] != "binance" {
+		t.Fatalf("got providers %v, want [gecko binance]", got)
+	}
+	if b := Providers[1].(*BinanceProvider); b.apiKey != "key" {
+		t.Fatalf("got api key %q, want %q", b.apiKey, "key")
+	}
+}
+
+func TestInitPutsStreamFirstWhenServing(t *testing.T) {
+	defer func(mode string) { Mode = mode }(Mode)
+	t.Setenv("PRICE_PROVIDERS", "binance")
+	Init()
+	if got := providerNames(Providers); len(got) != 1 || StartStream(context.Background()) {
+		t.Fatalf("got providers %v, want only binance without an HTTP server", got)
+	}
+
+	t.Setenv("HTTP_PORT", "7575")
+	t.Setenv("BINANCE_STREAM_URL", "ws://127.0.0.1:1")
+	Init()
+	if got := providerNames(Providers); len(got) != 2 || got[0] != "binance-stream" || got[1] != "binance" {
+		t.Fatalf("got providers %v, want [binance-stream binance]", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !StartStream(ctx) {
+		t.Fatal("expected the configured stream to be opened")
+	}
+
+	// Replayed prices are never mixed with live ones, and Binance is not asked when it is not configured
+	for _, names := range []string{"replay", "kraken,coinbase"} {
+		t.Setenv("PRICE_PROVIDERS", names)
+		Init()
+		if got := strings.Join(providerNames(Providers), ","); got != names {
+			t.Fatalf("got providers %v, want %s", got, names)
+		}
+	}
+
+	// Consensus would count Binance twice
+	t.Setenv("PRICE_PROVIDERS", "binance,kraken")
+	t.Setenv("PRICE_MODE", "consensus")
+	Init()
+	if got := strings.Join(providerNames(Providers), ","); got != "binance,kraken" {
+		t.Fatalf("got providers %v, want binance,kraken", got)
+	}
+}
+
+func TestGetSupportedCoinByIdOrSymbol(t *testing.T) {
+	for _, lookup := range []string{"ethereum", "ETH", "eth"} {
+		coin, ok := GetSupportedCoin(lookup)
+		if !ok || coin.Id != "ethereum" {
+			t.Fatalf("lookup %q: got %v, want ethereum", lookup, coin)
+		}
+	}
+	if _, ok := GetSupportedCoin("notacoin"); ok {
+		t.Fatal("expected unknown coin not to be supported")
+	}
+}
+
+func TestFallbackExchangeRateConvertsCurrency(t *testing.T) {
+	useProviders(&stubProvider{name: "first", price: 100})
+	FX = StaticFXSource{"USD": 1, "USDT": 0.99, "EUR": 1.1}
+
+	result, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], "EUR")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.CoinValueCurrency != "EUR" || math.Abs(result.CoinValue-90) > 1e-9 {
+		t.Fatalf("got %v %s, want 90 EUR", result.CoinValue, result.CoinValueCurrency)
+	}
+
+	result, err = getFallbackExchangeRate(context.Background(), SupportedCoins[0], "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.CoinValueCurrency != "USDT" || result.CoinValue != 100 {
+		t.Fatalf("got %v %s, want 100 USDT", result.CoinValue, result.CoinValueCurrency)
+	}
+}
+
+func TestFallbackExchangeRateSkipsUnconvertible(t *testing.T) {
+	useProviders(&stubProvider{name: "first", price: 100})
+	FX = StaticFXSource{"USD": 1}
+
+	if _, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], "ZAR"); err == nil {
+		t.Fatal("expected an error when the price cannot be converted")
+	}
+}
+
+func TestConsensusRejectsOutliers(t *testing.T) {
+	useProviders(
+		&stubProvider{name: "a", price: 100},
+		&stubProvider{name: "b", price: 101},
+		&stubProvider{name: "c", price: 150},
+	)
+	FX = StaticFXSource{"USDT": 1}
+	OutlierThreshold = 0.01
+
+	result, err := GetConsensusExchangeRate(context.Background(), SupportedCoins[0], "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.CoinValue != 100.5 {
+		t.Fatalf("got %v, want the median of the accepted prices 100.5", result.CoinValue)
+	}
+	if len(result.Consensus.Sources) != 3 || !result.Consensus.Sources[2].Outlier {
+		t.Fatalf("expected c to be marked as an outlier, got %+v", result.Consensus.Sources)
+	}
+	if result.Consensus.Spread != 1 {
+		t.Fatalf("got spread %v, want 1", result.Consensus.Spread)
+	}
+}
+
+func TestConsensusIgnoresSlowProviders(t *testing.T) {
+	useProviders(
+		&stubProvider{name: "fast", price: 100},
+		&stubProvider{name: "slow", price: 100, delay: time.Second},
+	)
+	ConsensusTimeout = 50 * time.Millisecond
+	defer func() { ConsensusTimeout = 2 * time.Second }()
+
+	result, err := GetConsensusExchangeRate(context.Background(), SupportedCoins[0], "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Consensus.Sources) != 1 || result.Consensus.Sources[0].Provider != "fast" {
+		t.Fatalf("expected only the fast provider, got %+v", result.Consensus.Sources)
+	}
+}
+
+func TestCacheCoalescesConcurrentRequests(t *testing.T) {
+	provider := &stubProvider{name: "slow", price: 100, delay: 50 * time.Millisecond}
+	useProviders(provider)
+	Mode = "fallback"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := GetCurrentExchangeRate(context.Background(), SupportedCoins[0], ""); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if provider.calls != 1 {
+		t.Fatalf("got %d provider calls, want 1", provider.calls)
+	}
+}
+
+func TestCacheHonoursMaxAge(t *testing.T) {
+	provider := &stubProvider{name: "first", price: 100}
+	useProviders(provider)
+	Mode = "fallback"
+
+	GetExchangeRateWithin(context.Background(), SupportedCoins[0], "", time.Minute)
+	result, _ := GetExchangeRateWithin(context.Background(), SupportedCoins[0], "", time.Minute)
+	if provider.calls != 1 {
+		t.Fatalf("got %d provider calls, want the second request to be cached", provider.calls)
+	}
+	if result.Age() > time.Minute {
+		t.Fatalf("got cached price age %v", result.Age())
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	GetExchangeRateWithin(context.Background(), SupportedCoins[0], "", time.Millisecond)
+	if provider.calls != 2 {
+		t.Fatalf("got %d provider calls, want a stale price to be fetched again", provider.calls)
+	}
+}
+
+// stubHistoryProvider is a stubProvider that returns one candle per interval from the requested start
+type stubHistoryProvider struct {
+	stubProvider
+}
+
+func (s *stubHistoryProvider) GetHistory(ctx context.Context, coin models.Coin, interval string, from, to time.Time, limit int) ([]models.Candle, error) {
+	var candles []models.Candle
+	for at := from; at.Before(to) && len(candles) < limit; at = at.Add(HistoryIntervals[interval]) {
+		candles = append(candles, models.Candle{OpenTime: models.TimestampTime{Time: at}, Close: s.price})
+	}
+	return candles, nil
+}
+
+func TestGetHistoryPages(t *testing.T) {
+	useProviders(
+		&stubProvider{name: "no-history", price: 1},
+		&stubHistoryProvider{stubProvider{name: "history", price: 100}},
+	)
+	from := time.Date(2024, 10, 12, 7, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Minute)
+
+	history, err := GetHistory(context.Background(), SupportedCoins[0], "1m", from, to, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if history.Provider != "history" || len(history.Candles) != 4 {
+		t.Fatalf("got %d candles from %s, want 4 from history", len(history.Candles), history.Provider)
+	}
+	if history.NextFrom == nil || !history.NextFrom.Equal(from.Add(4*time.Minute)) {
+		t.Fatalf("got next page at %v, want %v", history.NextFrom, from.Add(4*time.Minute))
+	}
+
+	history, _ = GetHistory(context.Background(), SupportedCoins[0], "1m", from.Add(8*time.Minute), to, 4)
+	if len(history.Candles) != 2 || history.NextFrom != nil {
+		t.Fatalf("expected a final page of 2 candles, got %d (next %v)", len(history.Candles), history.NextFrom)
+	}
+}
+
+// candleProvider returns a single candle of the requested interval from 100 to 110 at the requested
+// start, or none for the intervals in missing
+type candleProvider struct {
+	stubProvider
+	missing []string
+}
+
+func (c *candleProvider) GetHistory(ctx context.Context, coin models.Coin, interval string, from, to time.Time, limit int) ([]models.Candle, error) {
+	if slices.Contains(c.missing, interval) {
+		return nil, nil
+	}
+	return []models.Candle{{
+		OpenTime:  models.TimestampTime{Time: from},
+		CloseTime: models.TimestampTime{Time: from.Add(HistoryIntervals[interval] - time.Millisecond)},
+		Open:      100,
+		Close:     110,
+	}}, nil
+}
+
+func TestGetPriceAtUsesNearestEndOfCandle(t *testing.T) {
+	defer func(fx FXRateSource) { FX = fx }(FX)
+	FX = StaticFXSource{"USD": 1, "USDT": 1, "EUR": 2}
+	useProviders(&candleProvider{stubProvider: stubProvider{name: "candles"}})
+	second := time.Date(2024, 10, 12, 7, 20, 10, 0, time.UTC)
+
+	result, err := GetPriceAt(context.Background(), SupportedCoins[0], "", second.Add(200*time.Millisecond), time.Second)
+	if err != nil || result.CoinValue != 100 || !result.QueryTime.Equal(second) {
+		t.Fatalf("expected the open of the one second candle, got %+v, %v", result, err)
+	}
+
+	// 1 EUR is worth 2 USDT
+	result, err = GetPriceAt(context.Background(), SupportedCoins[0], "EUR", second.Add(800*time.Millisecond), time.Second)
+	if err != nil || result.CoinValue != 55 || result.CoinValueCurrency != "EUR" || !result.QueryTime.After(second.Add(900*time.Millisecond)) {
+		t.Fatalf("expected the close of the one second candle in EUR, got %+v, %v", result, err)
+	}
+}
+
+func TestGetPriceAtFallsBackToMinuteCandlesWithinDistance(t *testing.T) {
+	useProviders(&candleProvider{stubProvider: stubProvider{name: "candles"}, missing: []string{"1s"}})
+	minute := time.Date(2024, 10, 12, 7, 20, 0, 0, time.UTC)
+
+	result, err := GetPriceAt(context.Background(), SupportedCoins[0], "", minute.Add(2*time.Second), 3*time.Second)
+	if err != nil || result.CoinValue != 100 || !result.QueryTime.Equal(minute) {
+		t.Fatalf("expected the open of the one minute candle, got %+v, %v", result, err)
+	}
+
+	// Both ends of the minute are further than the allowed distance from the time
+	if result, err := GetPriceAt(context.Background(), SupportedCoins[0], "", minute.Add(30*time.Second), 3*time.Second); err == nil {
+		t.Fatalf("expected no price half a minute from either end of the candle, got %+v", result)
+	}
+}
+
+func TestCandlesFromPrices(t *testing.T) {
+	from := time.Date(2024, 10, 12, 7, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) float64 { return float64(from.Add(offset).UnixMilli()) }
+	points := [][]float64{
+		{at(0), 10}, {at(20 * time.Second), 12}, {at(40 * time.Second), 9}, {at(59 * time.Second), 11},
+		{at(time.Minute), 20},
+	}
+
+	candles := candlesFromPrices(points, time.Minute, from, from.Add(time.Hour), 10)
+	if len(candles) != 2 {
+		t.Fatalf("got %d candles, want 2", len(candles))
+	}
+	if got := candles[0]; got.Open != 10 || got.High != 12 || got.Low != 9 || got.Close != 11 {
+		t.Fatalf("got candle %+v, want open 10 high 12 low 9 close 11", got)
+	}
+}
+
+func TestCircuitBreakerSkipsFailingProvider(t *testing.T) {
+	failing := &stubProvider{name: "failing", err: models.ReturnError{ErrorMessage: "down"}}
+	working := &stubProvider{name: "working", price: 100}
+	useProviders(failing, working)
+	FailureThreshold = 2
+	BreakerCooldown = 20 * time.Millisecond
+	defer func() { FailureThreshold, BreakerCooldown = 3, 30*time.Second }()
+
+	for i := 0; i < 4; i++ {
+		if _, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if failing.calls != 2 {
+		t.Fatalf("got %d calls to the failing provider, want the breaker to open after 2", failing.calls)
+	}
+	if health := GetProviderHealth(); health[0].State != "open" || health[0].TotalFailures != 2 || health[1].TotalSuccesses != 4 {
+		t.Fatalf("got provider health %+v", health)
+	}
+
+	// After the cooldown a single trial call goes through, and it succeeding closes the breaker
+	time.Sleep(30 * time.Millisecond)
+	failing.err = nil
+	failing.price = 99
+	result, _ := getFallbackExchangeRate(context.Background(), SupportedCoins[0], "")
+	if result.Provider != "failing" || GetProviderHealth()[0].State != "closed" {
+		t.Fatalf("expected the trial call to close the breaker, got %s from %s", GetProviderHealth()[0].State, result.Provider)
+	}
+}
+
+func TestCircuitBreakerReopensOnFailedTrial(t *testing.T) {
+	FailureThreshold = 1
+	BreakerCooldown = 10 * time.Millisecond
+	ResetBreakers()
+	defer func() { FailureThreshold, BreakerCooldown = 3, 30*time.Second }()
+
+	recordResult("p", models.ReturnError{ErrorMessage: "down"})
+	if allowCall("p") {
+		t.Fatal("expected an open breaker to refuse calls")
+	}
+	time.Sleep(15 * time.Millisecond)
+	if !allowCall("p") || allowCall("p") {
+		t.Fatal("expected exactly one trial call once the cooldown passed")
+	}
+	recordResult("p", models.ReturnError{ErrorMessage: "still down"})
+	if allowCall("p") {
+		t.Fatal("expected a failed trial call to open the breaker again")
+	}
+}
+
+// blockingProvider answers once released, or fails as soon as its context is cancelled
+type blockingProvider struct {
+	release   chan struct{}
+	cancelled chan struct{}
+}
+
+func (b *blockingProvider) Name() string          { return "blocking" }
+func (b *blockingProvider) QuoteCurrency() string { return "USDT" }
+
+func (b *blockingProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	select {
+	case <-b.release:
+		price := 100.0
+		return &price, nil
+	case <-ctx.Done():
+		close(b.cancelled)
+		return nil, ctx.Err()
+	}
+}
+
+func TestCancelledCallerAbortsFetch(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{}), cancelled: make(chan struct{})}
+	useProviders(provider)
+	Mode = "fallback"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := GetCurrentExchangeRate(ctx, SupportedCoins[0], "")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got %v, want the caller to see its own cancellation", err)
+	}
+	select {
+	case <-provider.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the provider call to be cancelled")
+	}
+	if health := GetProviderHealth(); health[0].TotalFailures != 0 {
+		t.Fatalf("expected a cancelled call not to count as a provider failure, got %+v", health[0])
+	}
+}
+
+func TestCancelledCallerDoesNotAbortSharedFetch(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{}), cancelled: make(chan struct{})}
+	useProviders(provider)
+	Mode = "fallback"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go GetCurrentExchangeRate(ctx, SupportedCoins[0], "")
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		_, err := GetCurrentExchangeRate(context.Background(), SupportedCoins[0], "")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	close(provider.release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("expected the remaining caller to get a price, got %v", err)
+	}
+}
+
+// quotedProvider is a PriceProvider that reports when its price was quoted
+type quotedProvider struct {
+	stubProvider
+	quotedAt time.Time
+}
+
+func (q *quotedProvider) GetCurrentQuote(ctx context.Context, coin models.Coin) (*Quote, error) {
+	return &Quote{Price: q.price, Time: q.quotedAt}, nil
+}
+
+func TestFallbackSkipsNonPositivePrices(t *testing.T) {
+	zero := &stubProvider{name: "zero", price: 0}
+	negative := &stubProvider{name: "negative", price: -1}
+	working := &stubProvider{name: "working", price: 42}
+	useProviders(zero, negative, working)
+
+	result, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Provider != "working" || result.CoinValue != 42 {
+		t.Fatalf("got %v from %s, want 42 from working", result.CoinValue, result.Provider)
+	}
+}
+
+func TestFallbackSkipsStaleQuotes(t *testing.T) {
+	stale := &quotedProvider{stubProvider: stubProvider{name: "stale", price: 41}, quotedAt: time.Now().Add(-2 * QuoteMaxAge)}
+	fresh := &quotedProvider{stubProvider: stubProvider{name: "fresh", price: 42}, quotedAt: time.Now()}
+	useProviders(stale, fresh)
+
+	result, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Provider != "fresh" {
+		t.Fatalf("got price from %s, want fresh", result.Provider)
+	}
+}
+
+func TestFallbackSkipsImplausibleQuotes(t *testing.T) {
+	good := &stubProvider{name: "good", price: 100}
+	useProviders(good)
+	if _, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A quote far from the last known good price falls through to the next provider
+	wild := &stubProvider{name: "wild", price: 1000}
+	Providers = []PriceProvider{wild, good}
+	ResetCache()
+
+	result, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Provider != "good" || result.CoinValue != 100 {
+		t.Fatalf("got %v from %s, want 100 from good", result.CoinValue, result.Provider)
+	}
+}
+
+func TestValidateQuoteIgnoresOldReference(t *testing.T) {
+	ResetQuotes()
+	provider := &stubProvider{name: "provider"}
+	coin := SupportedCoins[0]
+
+	rememberQuote(provider, coin, 100)
+	if err := validateQuote(provider, coin, Quote{Price: 150, Time: time.Now()}); err == nil {
+		t.Fatal("expected a 50% move to be rejected")
+	}
+
+	// Once the reference is too old, a large move is accepted
+	lastGoodMu.Lock()
+	lastGood[quoteKey(provider, coin)] = Quote{Price: 100, Time: time.Now().Add(-2 * QuoteReferenceMaxAge)}
+	lastGoodMu.Unlock()
+
+	if err := validateQuote(provider, coin, Quote{Price: 150, Time: time.Now()}); err != nil {
+		t.Fatalf("expected a move after an old reference to be accepted, got %v", err)
+	}
+}
+
+// movingProvider is a PriceProvider whose price can be changed while it is in use
+type movingProvider struct {
+	mu    sync.Mutex
+	price float64
+}
+
+func (m *movingProvider) Name() string {
+	return "moving"
+}
+
+func (m *movingProvider) QuoteCurrency() string {
+	return "USDT"
+}
+
+func (m *movingProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	price := m.price
+	return &price, nil
+}
+
+func (m *movingProvider) move(price float64) {
+	m.mu.Lock()
+	m.price = price
+	m.mu.Unlock()
+}
+
+func nextTick(t *testing.T, ticks <-chan PriceTick) PriceTick {
+	select {
+	case tick, ok := <-ticks:
+		if !ok {
+			t.Fatal("price feed closed unexpectedly")
+		}
+		return tick
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a price tick")
+	}
+	return PriceTick{}
+}
+
+func TestPriceFeedPublishesChangesAndResumes(t *testing.T) {
+	defer func(interval time.Duration) { FeedInterval = interval }(FeedInterval)
+	FeedInterval = 10 * time.Millisecond
+
+	provider := &movingProvider{price: 100}
+	useProviders(provider)
+	bitcoin, _ := GetSupportedCoin("btc")
+
+	_, ticks, unsubscribe := SubscribePrices(*bitcoin, "", 0)
+	defer unsubscribe()
+
+	first := nextTick(t, ticks)
+	if first.Result.CoinValue != 100 {
+		t.Fatalf("got %v, want 100", first.Result.CoinValue)
+	}
+	provider.move(101)
+	second := nextTick(t, ticks)
+	if second.Result.CoinValue != 101 || second.Id <= first.Id {
+		t.Fatalf("got tick %d at %v, want a later tick at 101", second.Id, second.Result.CoinValue)
+	}
+
+	// A client resuming after the first tick is sent the second from the backlog
+	backlog, _, unsubscribeResumed := SubscribePrices(*bitcoin, "", first.Id)
+	defer unsubscribeResumed()
+	if len(backlog) != 1 || backlog[0].Id != second.Id {
+		t.Fatalf("got backlog %v, want only tick %d", backlog, second.Id)
+	}
+
+	// A new client is sent the latest price straight away
+	backlog, _, unsubscribeNew := SubscribePrices(*bitcoin, "", 0)
+	defer unsubscribeNew()
+	if len(backlog) != 1 || backlog[0].Result.CoinValue != 101 {
+		t.Fatalf("got backlog %v, want the latest price only", backlog)
+	}
+}
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func replayPrice(t *testing.T, provider *ReplayProvider, coinId string) float64 {
+	coin, _ := GetSupportedCoin(coinId)
+	price, err := provider.GetCurrentExchangeRate(context.Background(), *coin)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return *price
+}
+
+func TestReplayRandomWalkIsDeterministic(t *testing.T) {
+	walk := func(seed int64) []float64 {
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		provider, err := NewReplay(ReplayConfig{Seed: seed, Interval: time.Second, Now: clock.Now})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var prices []float64
+		for i := 0; i < 5; i++ {
+			prices = append(prices, replayPrice(t, provider, "btc"))
+			clock.now = clock.now.Add(time.Minute)
+		}
+		return prices
+	}
+
+	first, second, other := walk(42), walk(42), walk(7)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("step %d: got %v and %v for the same seed", i, first[i], second[i])
+		}
+	}
+	if first[0] != replayStartPrices["bitcoin"] || first[4] == other[4] {
+		t.Fatalf("expected the walk to start at the start price and depend on the seed, got %v and %v", first, other)
+	}
+}
+
+func TestReplayTickFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ticks.csv": "time,coin,price\n" +
+			"2024-01-01T00:00:00Z,btc,100\n" +
+			"2024-01-01T00:01:00Z,btc,110\n" +
+			"2024-01-01T00:00:00Z,eth,10\n",
+		"ticks.ndjson": `{"time":"2024-01-01T00:00:00Z","coin":"bitcoin","price":100}` + "\n" +
+			`{"time":1704067260,"coin":"bitcoin","price":110}` + "\n" +
+			`{"time":"2024-01-01T00:00:00Z","coin":"ethereum","price":10}` + "\n",
+	}
+
+	for name, content := range files {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		clock := &fakeClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+		provider, err := NewReplay(ReplayConfig{File: path, Now: clock.Now})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		// Prices hold until the next tick and the file loops after the last tick has had its turn
+		for _, step := range []struct {
+			after time.Duration
+			want  float64
+		}{{0, 100}, {59 * time.Second, 100}, {time.Minute, 110}, {119 * time.Second, 110}, {2 * time.Minute, 100}} {
+			clock.now = provider.start.Add(step.after)
+			if got := replayPrice(t, provider, "btc"); got != step.want {
+				t.Errorf("%s: got %v after %v, want %v", name, got, step.after, step.want)
+			}
+		}
+		if got := replayPrice(t, provider, "eth"); got != 10 {
+			t.Errorf("%s: got eth %v, want 10", name, got)
+		}
+	}
+}
+
+// stubSummaryProvider is a PriceProvider that also reports a fixed market summary
+type stubSummaryProvider struct {
+	stubProvider
+	currency string
+	summary  models.MarketSummary
+}
+
+func (s *stubSummaryProvider) QuoteCurrency() string {
+	return s.currency
+}
+
+func (s *stubSummaryProvider) GetMarketSummary(ctx context.Context, coin models.Coin) (*models.MarketSummary, error) {
+	s.calls++
+	summary := s.summary
+	summary.CoinValueCurrency = s.currency
+	return &summary, nil
+}
+
+func TestMarketSummaryFillsMissingStatistics(t *testing.T) {
+	defer func(fx FXRateSource) { FX = fx }(FX)
+	FX = StaticFXSource{"USD": 1, "USDT": 0.5}
+
+	high, low, volume, change, marketCap := 110.0, 90.0, 5000.0, 1.5, 1e9
+	exchange := &stubSummaryProvider{stubProvider: stubProvider{name: "exchange"}, currency: "USDT", summary: models.MarketSummary{
+		CoinValue: 100, High24h: &high, Low24h: &low, Volume24h: &volume, PriceChangePercent24h: &change,
+	}}
+	aggregator := &stubSummaryProvider{stubProvider: stubProvider{name: "aggregator"}, currency: "USD", summary: models.MarketSummary{
+		CoinValue: 50, MarketCap: &marketCap,
+	}}
+	useProviders(&stubProvider{name: "no-summary"}, exchange, aggregator)
+
+	summary, err := GetMarketSummary(context.Background(), SupportedCoins[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.CoinValue != 100 || summary.CoinValueCurrency != "USDT" || *summary.High24h != 110 {
+		t.Fatalf("expected the first provider to set the price, got %+v", summary)
+	}
+	// 1 USD is worth 2 USDT
+	if summary.MarketCap == nil || *summary.MarketCap != 2e9 {
+		t.Fatalf("expected the market cap to be filled in and converted, got %v", summary.MarketCap)
+	}
+	if len(summary.Providers) != 2 || summary.Providers[1] != "aggregator" {
+		t.Fatalf("got providers %v, want exchange and aggregator", summary.Providers)
+	}
+
+	// A second request is served from the cache
+	if _, err := GetMarketSummary(context.Background(), SupportedCoins[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exchange.calls != 1 || aggregator.calls != 1 {
+		t.Fatalf("expected one call per provider, got %d and %d", exchange.calls, aggregator.calls)
+	}
+}
+
+// useQuota meters a single provider, forgetting any other quota
+func useQuota(provider string, limit, reserve int) {
+	quotasMu.Lock()
+	quotas = map[string]*quota{provider: {limit: limit, reserve: reserve}}
+	quotasMu.Unlock()
+}
+
+func TestQuotaKeepsReserveForFallback(t *testing.T) {
+	metered := &stubProvider{name: "metered", price: 42}
+	backup := &stubProvider{name: "backup", price: 43}
+	useProviders(metered, backup)
+	useQuota("metered", 3, 2)
+	defer configureQuotas()
+
+	bitcoin, _ := GetSupportedCoin("btc")
+	result, err := getFallbackExchangeRate(context.Background(), *bitcoin, "")
+	if err != nil || result.Provider != "metered" {
+		t.Fatalf("expected the metered provider to answer, got %+v, %v", result, err)
+	}
+	// Only the reserve is left, which the first provider in the chain may not use
+	result, err = getFallbackExchangeRate(context.Background(), *bitcoin, "")
+	if err != nil || result.Provider != "backup" {
+		t.Fatalf("expected the backup provider to answer, got %+v, %v", result, err)
+	}
+	if usage := GetQuotaUsage(context.Background()); len(usage) != 1 || !usage[0].FallbackOnly || usage[0].Remaining != 2 {
+		t.Fatalf("expected the quota to be fallback only with 2 calls left, got %+v", usage)
+	}
+
+	// Fallback calls use up the reserve
+	for i := 0; i < 2; i++ {
+		if err := spendQuota(withFallback(context.Background()), "metered"); err != nil {
+			t.Fatalf("fallback call %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := spendQuota(withFallback(context.Background()), "metered"); err == nil {
+		t.Fatal("expected an exhausted quota to refuse fallback calls")
+	}
+	if usage := GetQuotaUsage(context.Background()); !usage[0].Exhausted || usage[0].Calls != 3 {
+		t.Fatalf("expected the quota to be exhausted after 3 calls, got %+v", usage)
+	}
+	if metered.calls != 1 {
+		t.Fatalf("expected the metered provider to be called once, got %d", metered.calls)
+	}
+}
+
+// usageDB only stores provider usage; any other call panics
+type usageDB struct {
+	db.DBInterface
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (u *usageDB) IncrementProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.calls[provider+"/"+window]++
+	return &models.ProviderUsage{Provider: provider, Window: window, Calls: u.calls[provider+"/"+window]}, nil
+}
+
+func (u *usageDB) GetProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return &models.ProviderUsage{Provider: provider, Window: window, Calls: u.calls[provider+"/"+window]}, nil
+}
+
+func TestQuotaNotSpentWhileCircuitOpen(t *testing.T) {
+	failing := &stubProvider{name: "metered", err: models.ReturnError{ErrorMessage: "down"}}
+	working := &stubProvider{name: "working", price: 100}
+	useProviders(failing, working)
+	useQuota("metered", 100, 0)
+	defer configureQuotas()
+	FailureThreshold = 2
+	defer func() { FailureThreshold = 3 }()
+
+	for i := 0; i < 5; i++ {
+		if _, err := getFallbackExchangeRate(context.Background(), SupportedCoins[0], ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Only the two calls made before the circuit opened are counted
+	if usage := GetQuotaUsage(context.Background()); usage[0].Calls != 2 || failing.calls != 2 {
+		t.Fatalf("expected 2 calls counted against the quota, got %+v after %d calls", usage, failing.calls)
+	}
+}
+
+func TestQuotaPersistsUsagePerWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 10, 31, 23, 59, 0, 0, time.UTC)}
+	stored := &usageDB{calls: map[string]int{"metered/2024-10": 5}}
+	defer func(previous db.DBInterface, now func() time.Time) { db.DB, quotaClock = previous, now }(db.DB, quotaClock)
+	db.DB, quotaClock = stored, clock.Now
+	// Calls made before a cold start are picked up from the database
+	useQuota("metered", 6, 0)
+	defer configureQuotas()
+
+	if err := spendQuota(context.Background(), "metered"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.calls["metered/2024-10"] != 6 {
+		t.Fatalf("expected the call to be stored, got %d calls", stored.calls["metered/2024-10"])
+	}
+	if err := spendQuota(context.Background(), "metered"); err == nil {
+		t.Fatal("expected the quota to be exhausted")
+	}
+
+	// A new billing window starts from zero
+	clock.now = clock.now.Add(time.Minute)
+	if err := spendQuota(context.Background(), "metered"); err != nil {
+		t.Fatalf("unexpected error in the new window: %v", err)
+	}
+	if usage := GetQuotaUsage(context.Background()); usage[0].Window != "2024-11" || usage[0].Calls != 1 {
+		t.Fatalf("expected 1 call in 2024-11, got %+v", usage)
+	}
+}
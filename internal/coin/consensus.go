@@ -0,0 +1,140 @@
+package coin
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// ConsensusTimeout is how long a consensus query waits for providers to answer
+var ConsensusTimeout = 2 * time.Second
+
+// OutlierThreshold is the maximum relative deviation from the median a price may have before
+// it is rejected as an outlier (0.01 = 1%)
+var OutlierThreshold = 0.01
+
+type sourceResult struct {
+	provider PriceProvider
+	rate     *float64
+	err      error
+}
+
+// GetConsensusExchangeRate queries every provider concurrently, drops prices that deviate too far
+// from the median and returns the median of the remaining prices. Providers that do not answer
+// before ConsensusTimeout are cancelled and ignored.
+func GetConsensusExchangeRate(ctx context.Context, coin models.Coin, currency string) (*models.CoinResult, error) {
+	if len(Providers) == 0 {
+		return nil, models.ReturnError{ErrorMessage: "Could not determine current exchange rate"}
+	}
+	// Prices have to be in the same currency to be compared
+	if currency == "" {
+		currency = Providers[0].QuoteCurrency()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ConsensusTimeout)
+	defer cancel()
+
+	// Buffered so that providers answering after the deadline do not block
+	results := make(chan sourceResult, len(Providers))
+	for _, provider := range Providers {
+		go func(provider PriceProvider) {
+			rate, err := fetchQuote(ctx, provider, coin)
+			results <- sourceResult{provider: provider, rate: rate, err: err}
+		}(provider)
+	}
+
+	var sources []models.SourcePrice
+	// Raw quotes by provider, remembered as last known good prices once outliers are known
+	quotes := map[string]sourceResult{}
+collect:
+	for range Providers {
+		select {
+		case result := <-results:
+			if result.err != nil {
+				log.Printf("Price provider %s failed: %v", result.provider.Name(), result.err)
+				continue
+			}
+			rate, err := Convert(ctx, *result.rate, result.provider.QuoteCurrency(), currency)
+			if err != nil {
+				log.Printf("Could not convert %s price to %s: %v", result.provider.Name(), currency, err)
+				continue
+			}
+			sources = append(sources, models.SourcePrice{Provider: result.provider.Name(), Price: *rate})
+			quotes[result.provider.Name()] = result
+		case <-ctx.Done():
+			log.Printf("Consensus query ended (%v) with %d price(s)", ctx.Err(), len(sources))
+			break collect
+		}
+	}
+
+	price, consensus, err := aggregatePrices(sources)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range consensus.Sources {
+		if !source.Outlier {
+			quote := quotes[source.Provider]
+			rememberQuote(quote.provider, coin, *quote.rate)
+		}
+	}
+
+	return &models.CoinResult{
+		Coin:              coin.Id,
+		CoinValue:         price,
+		CoinValueCurrency: currency,
+		Provider:          con.PROVIDER_CONSENSUS,
+		QueryTime:         models.TimestampTime{Time: time.Now()},
+		Consensus:         consensus,
+	}, nil
+}
+
+// aggregatePrices marks outliers and returns the median of the accepted prices, along with the
+// breakdown of every source
+func aggregatePrices(sources []models.SourcePrice) (float64, *models.PriceConsensus, error) {
+	if len(sources) == 0 {
+		return 0, nil, models.ReturnError{ErrorMessage: "Could not determine current exchange rate"}
+	}
+
+	// Sort by provider so the breakdown is stable regardless of who answered first
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Provider < sources[j].Provider })
+
+	prices := make([]float64, 0, len(sources))
+	for _, source := range sources {
+		prices = append(prices, source.Price)
+	}
+	mid := median(prices)
+
+	var accepted []float64
+	for i := range sources {
+		if mid == 0 || math.Abs(sources[i].Price-mid)/mid > OutlierThreshold {
+			sources[i].Outlier = true
+			continue
+		}
+		accepted = append(accepted, sources[i].Price)
+	}
+
+	if len(accepted) == 0 {
+		return 0, nil, models.ReturnError{ErrorMessage: "Price providers do not agree on the current exchange rate"}
+	}
+
+	sort.Float64s(accepted)
+	return median(accepted), &models.PriceConsensus{
+		Sources: sources,
+		Spread:  accepted[len(accepted)-1] - accepted[0],
+	}, nil
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,144 @@
+package coin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JulianToledano/goingecko"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// SupportedCurrencies are the quote currencies prices can be requested in
+var SupportedCurrencies = []string{
+	con.COIN_CURRENCY_USD,
+	con.COIN_CURRENCY_USDT,
+	con.COIN_CURRENCY_EUR,
+	con.COIN_CURRENCY_GBP,
+	con.COIN_CURRENCY_ZAR,
+}
+
+// GetSupportedCurrency normalises a currency code and checks that it is supported
+func GetSupportedCurrency(currency string) (string, bool) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	for _, supported := range SupportedCurrencies {
+		if supported == currency {
+			return supported, true
+		}
+	}
+	return "", false
+}
+
+// FXRateSource provides conversion rates between quote currencies
+type FXRateSource interface {
+	// GetRate returns how many units of the to currency one unit of the from currency is worth
+	GetRate(ctx context.Context, from, to string) (*float64, error)
+}
+
+// FX is the source used to convert provider prices into the requested quote currency
+var FX FXRateSource
+
+// Convert converts an amount from one quote currency into another using the FX source
+func Convert(ctx context.Context, amount float64, from, to string) (*float64, error) {
+	if from == to {
+		return &amount, nil
+	}
+	if FX == nil {
+		return nil, models.ReturnError{ErrorMessage: "No FX rate source configured"}
+	}
+
+	rate, err := FX.GetRate(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	converted := amount * *rate
+	return &converted, nil
+}
+
+// StaticFXSource converts using fixed rates, each expressed as the value of one unit of the
+// currency in USD
+type StaticFXSource map[string]float64
+
+func (s StaticFXSource) GetRate(ctx context.Context, from, to string) (*float64, error) {
+	fromUSD, okFrom := s[from]
+	toUSD, okTo := s[to]
+	if !okFrom || !okTo || toUSD == 0 {
+		return nil, models.ReturnError{ErrorMessage: fmt.Sprintf("No FX rate from %s to %s", from, to)}
+	}
+	rate := fromUSD / toUSD
+	return &rate, nil
+}
+
+// geckoFXRateTTL is how long FX rates fetched from CoinGecko are reused for
+const geckoFXRateTTL = 5 * time.Minute
+
+// GeckoFXSource derives FX rates from the price of Tether (USDT) in every supported currency on
+// CoinGecko, which gives us both the fiat cross rates and the USDT rate in a single call
+type GeckoFXSource struct {
+	apiKey    string
+	mu        sync.Mutex
+	rates     StaticFXSource
+	fetchedAt time.Time
+}
+
+// NewGeckoFXSource creates an FX rate source backed by CoinGecko
+func NewGeckoFXSource(apiKey string) *GeckoFXSource {
+	return &GeckoFXSource{apiKey: apiKey}
+}
+
+func (g *GeckoFXSource) GetRate(ctx context.Context, from, to string) (*float64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.rates == nil || time.Since(g.fetchedAt) > geckoFXRateTTL {
+		rates, err := g.fetchRates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		g.rates = rates
+		g.fetchedAt = time.Now()
+	}
+
+	return g.rates.GetRate(ctx, from, to)
+}
+
+func (g *GeckoFXSource) fetchRates(ctx context.Context) (StaticFXSource, error) {
+	// No other provider has FX rates, so they may use the reserve
+	if err := spendQuota(withFallback(ctx), con.PROVIDER_GECKO); err != nil {
+		return nil, err
+	}
+
+	cgClient := goingecko.NewClient(contextHTTPClient(ctx), g.apiKey)
+	defer cgClient.Close()
+
+	var vsCurrencies []string
+	for _, currency := range SupportedCurrencies {
+		if currency != con.COIN_CURRENCY_USDT {
+			vsCurrencies = append(vsCurrencies, strings.ToLower(currency))
+		}
+	}
+
+	data, err := cgClient.SimplePrice("tether", strings.Join(vsCurrencies, ","), false, false, false, false)
+	if err != nil {
+		return nil, models.ReturnError{ErrorMessage: "Failed to retrieve FX rates from CoinGecko API"}
+	}
+
+	tether := data["tether"]
+	usd := tether["usd"]
+	if usd == 0 {
+		return nil, models.ReturnError{ErrorMessage: "CoinGecko returned no USD rate for Tether"}
+	}
+
+	// Rates are stored as the value of one unit of each currency in USD
+	rates := StaticFXSource{con.COIN_CURRENCY_USDT: usd}
+	for currency, price := range tether {
+		if price != 0 {
+			rates[strings.ToUpper(currency)] = usd / price
+		}
+	}
+	return rates, nil
+}
@@ -0,0 +1,142 @@
+package coin
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"hermes-crypto-core/internal/models"
+)
+
+// FeedInterval is how often a price feed refreshes its price. Prices come from the shared price
+// cache, so they are fetched from the providers at most once per interval however many feeds there are.
+var FeedInterval = time.Second
+
+// FeedHistorySize is the number of recent ticks a price feed keeps for subscribers resuming a stream
+var FeedHistorySize = 100
+
+// feedBuffer is the number of ticks a subscriber may fall behind before it is dropped
+const feedBuffer = 16
+
+// PriceTick is a price published by a feed. Ids increase with every tick and are never reused
+// while the process runs, so a subscriber can resume even if its feed was restarted.
+type PriceTick struct {
+	Id     uint64
+	Result models.CoinResult
+}
+
+// priceFeed polls the price of a single coin and currency on behalf of every subscriber, so that
+// the number of subscribers does not change the number of provider calls
+type priceFeed struct {
+	coin     models.Coin
+	currency string
+	cancel   context.CancelFunc
+
+	// Guarded by feedsMu
+	history     []PriceTick
+	subscribers map[chan PriceTick]struct{}
+}
+
+var (
+	feedsMu    sync.Mutex
+	feeds      = map[string]*priceFeed{}
+	lastTickId uint64
+)
+
+// SubscribePrices subscribes to the price feed of a coin in the requested currency, starting the
+// feed if nobody is subscribed yet. Ticks after lastId that are still remembered are returned as a
+// backlog (a new subscriber passing 0 gets the latest tick only), followed by new ticks on the
+// channel. The channel is closed if the subscriber falls too far behind; it can then resubscribe
+// from the last tick it has seen. Call unsubscribe when done.
+func SubscribePrices(coin models.Coin, currency string, lastId uint64) (backlog []PriceTick, ticks <-chan PriceTick, unsubscribe func()) {
+	key := cacheKey(coin, currency)
+
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+
+	feed, ok := feeds[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		feed = &priceFeed{coin: coin, currency: currency, cancel: cancel, subscribers: map[chan PriceTick]struct{}{}}
+		feeds[key] = feed
+		go feed.run(ctx)
+	}
+
+	if lastId == 0 && len(feed.history) > 0 {
+		backlog = []PriceTick{feed.history[len(feed.history)-1]}
+	} else {
+		for _, tick := range feed.history {
+			if tick.Id > lastId {
+				backlog = append(backlog, tick)
+			}
+		}
+	}
+
+	subscriber := make(chan PriceTick, feedBuffer)
+	feed.subscribers[subscriber] = struct{}{}
+
+	return backlog, subscriber, func() {
+		feedsMu.Lock()
+		defer feedsMu.Unlock()
+
+		if _, ok := feed.subscribers[subscriber]; ok {
+			delete(feed.subscribers, subscriber)
+			close(subscriber)
+		}
+		// The last subscriber stops the feed
+		if len(feed.subscribers) == 0 && feeds[key] == feed {
+			feed.cancel()
+			delete(feeds, key)
+		}
+	}
+}
+
+// run publishes the price whenever it changes until the feed is stopped
+func (f *priceFeed) run(ctx context.Context) {
+	interval := FeedInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last models.CoinResult
+	for {
+		result, err := GetExchangeRateWithin(ctx, f.coin, f.currency, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Price feed for %s could not get a price: %v", f.coin.Id, err)
+		} else if result.CoinValue != last.CoinValue || result.Provider != last.Provider {
+			last = *result
+			f.publish(*result)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *priceFeed) publish(result models.CoinResult) {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+
+	lastTickId++
+	tick := PriceTick{Id: lastTickId, Result: result}
+	f.history = append(f.history, tick)
+	if len(f.history) > FeedHistorySize {
+		f.history = f.history[len(f.history)-FeedHistorySize:]
+	}
+
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber <- tick:
+		default:
+			// A subscriber that can not keep up is dropped rather than holding up the others
+			delete(f.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package coin
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hermes-crypto-core/internal/models"
+)
+
+// HistoryIntervals are the supported candle intervals and their length
+var HistoryIntervals = map[string]time.Duration{
+	"1s": time.Second,
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// DefaultHistoryLimit and MaxHistoryLimit bound the number of candles in a single page
+const (
+	DefaultHistoryLimit = 500
+	MaxHistoryLimit     = 1000
+)
+
+// HistoryProvider is a PriceProvider that can also provide historical candles
+type HistoryProvider interface {
+	PriceProvider
+	// GetHistory returns at most limit candles of the given interval, starting at from and ending before to
+	GetHistory(ctx context.Context, coin models.Coin, interval string, from, to time.Time, limit int) ([]models.Candle, error)
+}
+
+// GetHistory asks the providers in the chain that support history, in order, for a page of candles.
+// If the page is full, NextFrom is set to where the next page starts.
+func GetHistory(ctx context.Context, coin models.Coin, interval string, from, to time.Time, limit int) (*models.CoinHistory, error) {
+	length, ok := HistoryIntervals[interval]
+	if !ok {
+		return nil, models.ReturnError{ErrorMessage: "Interval " + interval + " is not supported"}
+	}
+
+	callCtx := ctx
+	for _, provider := range Providers {
+		historyProvider, ok := provider.(HistoryProvider)
+		if !ok {
+			continue
+		}
+
+		candles, err := guardedCall(callCtx, provider, func(ctx context.Context) ([]models.Candle, error) {
+			return historyProvider.GetHistory(ctx, coin, interval, from, to, limit)
+		})
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("History provider %s failed: %v", provider.Name(), err)
+			callCtx = withFallback(ctx)
+			continue
+		}
+
+		history := &models.CoinHistory{
+			Coin:              coin.Id,
+			CoinValueCurrency: provider.QuoteCurrency(),
+			Interval:          interval,
+			Provider:          provider.Name(),
+			Candles:           candles,
+		}
+		if len(candles) >= limit {
+			next := candles[len(candles)-1].OpenTime.Add(length)
+			if next.Before(to) {
+				history.NextFrom = &models.TimestampTime{Time: next}
+			}
+		}
+		return history, nil
+	}
+
+	return nil, models.ReturnError{ErrorMessage: "Could not determine price history"}
+}
+
+// priceAtIntervals are the candle intervals a past price is looked up in, most precise first. Providers
+// without one second candles fall back to the one minute candles.
+var priceAtIntervals = []string{"1s", "1m"}
+
+// GetPriceAt returns the price of a coin at a past time, optionally converted into the given currency.
+// It is taken from the open or close of the candle around that time, whichever is nearer, as long as
+// that is within the given distance of the time; QueryTime is the time of that price.
+func GetPriceAt(ctx context.Context, coin models.Coin, currency string, at time.Time, within time.Duration) (*models.CoinResult, error) {
+	var history *models.CoinHistory
+	var price float64
+	var priceTime time.Time
+	notFound := models.ReturnError{ErrorMessage: "No price history within " + within.String() + " of " + at.UTC().Format(time.RFC3339)}
+	for _, interval := range priceAtIntervals {
+		length := HistoryIntervals[interval]
+		from := at.Truncate(length)
+		page, err := GetHistory(ctx, coin, interval, from, from.Add(length), 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Candles) == 0 || page.Candles[0].Open <= 0 {
+			continue
+		}
+
+		candle := page.Candles[0]
+		nearest, nearestTime := candle.Open, candle.OpenTime.Time
+		// A candle that is still open closes at the latest trade, not at its close time
+		closeTime := candle.CloseTime.Time
+		if now := time.Now(); closeTime.After(now) {
+			closeTime = now
+		}
+		if closeTime.Sub(at) < at.Sub(nearestTime) && candle.Close > 0 {
+			nearest, nearestTime = candle.Close, closeTime
+		}
+		if nearestTime.Sub(at).Abs() <= within {
+			history, price, priceTime = page, nearest, nearestTime
+			break
+		}
+	}
+	if history == nil {
+		return nil, notFound
+	}
+
+	quoteCurrency := history.CoinValueCurrency
+	if currency != "" && currency != quoteCurrency {
+		converted, err := Convert(ctx, price, quoteCurrency, currency)
+		if err != nil {
+			return nil, err
+		}
+		price, quoteCurrency = *converted, currency
+	}
+
+	return &models.CoinResult{
+		Coin:              coin.Id,
+		CoinValue:         price,
+		CoinValueCurrency: quoteCurrency,
+		Provider:          history.Provider,
+		QueryTime:         models.TimestampTime{Time: priceTime},
+	}, nil
+}
+
+// candlesFromPrices buckets [unix ms, price] points into candles of the given length
+func candlesFromPrices(points [][]float64, length time.Duration, from, to time.Time, limit int) []models.Candle {
+	var candles []models.Candle
+	for _, point := range points {
+		if len(point) < 2 {
+			continue
+		}
+		at := time.UnixMilli(int64(point[0])).UTC()
+		if at.Before(from) || !at.Before(to) {
+			continue
+		}
+		price := point[1]
+		openTime := at.Truncate(length)
+
+		if n := len(candles); n > 0 && candles[n-1].OpenTime.Equal(openTime) {
+			candle := &candles[n-1]
+			candle.High = max(candle.High, price)
+			candle.Low = min(candle.Low, price)
+			candle.Close = price
+			continue
+		}
+		if len(candles) == limit {
+			break
+		}
+		candles = append(candles, models.Candle{
+			OpenTime:  models.TimestampTime{Time: openTime},
+			CloseTime: models.TimestampTime{Time: openTime.Add(length - time.Second)},
+			Open:      price,
+			High:      price,
+			Low:       price,
+			Close:     price,
+		})
+	}
+	return candles
+}
@@ -0,0 +1,207 @@
+package coin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/models"
+)
+
+// DefaultQuotas are the monthly call budgets of providers whose free plans are metered
+var DefaultQuotas = map[string]int{
+	con.PROVIDER_GECKO: 10000,
+}
+
+// DefaultQuotaReserve is the fraction of a quota kept for calls that no other provider can answer
+var DefaultQuotaReserve = 0.1
+
+// quotaClock is the clock deciding the billing window, replaced in tests
+var quotaClock = time.Now
+
+// quota is the call budget of a provider and the calls made in the current billing window
+type quota struct {
+	limit   int
+	reserve int
+	window  string
+	calls   int
+	loaded  bool // Whether the calls of the window have been read from the database
+}
+
+var (
+	quotasMu sync.Mutex
+	quotas   = map[string]*quota{}
+)
+
+// fallbackKey marks a context whose calls are made because no earlier provider could answer
+type fallbackKey struct{}
+
+// withFallback marks calls made with the context as fallback calls, which may use the reserve
+func withFallback(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fallbackKey{}, true)
+}
+
+func isFallback(ctx context.Context) bool {
+	fallback, _ := ctx.Value(fallbackKey{}).(bool)
+	return fallback
+}
+
+// quotaWindow returns the billing window of a time; providers bill per calendar month in UTC
+func quotaWindow(at time.Time) string {
+	return at.UTC().Format("2006-01")
+}
+
+// configureQuotas reads the quota of every known provider from <NAME>_MONTHLY_QUOTA, where 0
+// turns metering off, and the fraction kept for fallback calls from <NAME>_QUOTA_RESERVE
+func configureQuotas() {
+	quotasMu.Lock()
+	defer quotasMu.Unlock()
+
+	quotas = map[string]*quota{}
+	for name := range registry {
+		prefix := strings.ToUpper(name)
+		limit := DefaultQuotas[name]
+		if value, err := strconv.Atoi(os.Getenv(prefix + "_MONTHLY_QUOTA")); err == nil && value >= 0 {
+			limit = value
+		}
+		if limit == 0 {
+			continue
+		}
+		reserve := DefaultQuotaReserve
+		if value, err := strconv.ParseFloat(os.Getenv(prefix+"_QUOTA_RESERVE"), 64); err == nil && value >= 0 && value <= 1 {
+			reserve = value
+		}
+		quotas[name] = &quota{limit: limit, reserve: int(float64(limit) * reserve)}
+	}
+}
+
+// roll moves a quota to the given window, forgetting the calls of the previous one. Callers hold quotasMu.
+func (q *quota) roll(window string) {
+	if q.window != window {
+		q.window = window
+		q.calls = 0
+		q.loaded = false
+	}
+}
+
+// spendQuota counts a call to a provider against its quota, or returns an error if the call is not
+// allowed. Once only the reserve is left, just fallback calls are allowed. Counts are persisted so
+// they survive restarts and are shared between instances; instances racing for the last calls of a
+// window may overshoot the quota by a few calls.
+func spendQuota(ctx context.Context, provider string) error {
+	window := quotaWindow(quotaClock())
+
+	quotasMu.Lock()
+	q, ok := quotas[provider]
+	if !ok {
+		quotasMu.Unlock()
+		return nil
+	}
+	q.roll(window)
+	loaded := q.loaded
+	quotasMu.Unlock()
+
+	// Pick up the calls made before this instance started
+	if !loaded && db.DB != nil {
+		usage, err := db.DB.GetProviderUsage(ctx, provider, window)
+		if err != nil {
+			log.Printf("Could not read usage of price provider %s: %v", provider, err)
+		}
+		quotasMu.Lock()
+		if err == nil && q.window == window {
+			q.calls = max(q.calls, usage.Calls)
+			q.loaded = true
+		}
+		quotasMu.Unlock()
+	}
+
+	quotasMu.Lock()
+	remaining := q.limit - q.calls
+	if remaining <= 0 {
+		quotasMu.Unlock()
+		return models.ReturnError{ErrorMessage: fmt.Sprintf("Price provider %s has used its quota of %d calls for %s", provider, q.limit, window)}
+	}
+	if remaining <= q.reserve && !isFallback(ctx) {
+		quotasMu.Unlock()
+		return models.ReturnError{ErrorMessage: fmt.Sprintf("Price provider %s is keeping its last %d calls for %s as fallback", provider, remaining, window)}
+	}
+	q.calls++
+	quotasMu.Unlock()
+
+	if db.DB == nil {
+		return nil
+	}
+	// The stored count includes the calls of every other instance, so it replaces our own
+	usage, err := db.DB.IncrementProviderUsage(ctx, provider, window)
+	if err != nil {
+		// A call we could not record is still made, rather than failing the price over bookkeeping
+		log.Printf("Could not record usage of price provider %s: %v", provider, err)
+		return nil
+	}
+	quotasMu.Lock()
+	if q.window == window {
+		q.calls = max(q.calls, usage.Calls)
+	}
+	quotasMu.Unlock()
+	return nil
+}
+
+// GetQuotaUsage returns the calls made to every metered provider in the current billing window,
+// ordered by provider name
+func GetQuotaUsage(ctx context.Context) []models.ProviderUsage {
+	window := quotaWindow(quotaClock())
+
+	quotasMu.Lock()
+	names := make([]string, 0, len(quotas))
+	for name := range quotas {
+		names = append(names, name)
+	}
+	quotasMu.Unlock()
+	sort.Strings(names)
+
+	usages := make([]models.ProviderUsage, 0, len(names))
+	for _, name := range names {
+		// Other instances may have made calls since we last counted
+		var stored *models.ProviderUsage
+		if db.DB != nil {
+			var err error
+			if stored, err = db.DB.GetProviderUsage(ctx, name, window); err != nil {
+				log.Printf("Could not read usage of price provider %s: %v", name, err)
+			}
+		}
+
+		quotasMu.Lock()
+		q, ok := quotas[name]
+		if !ok {
+			quotasMu.Unlock()
+			continue
+		}
+		q.roll(window)
+		if stored != nil {
+			q.calls = max(q.calls, stored.Calls)
+			q.loaded = true
+		}
+		usage := models.ProviderUsage{
+			Provider:  name,
+			Window:    window,
+			Calls:     q.calls,
+			Quota:     q.limit,
+			Reserve:   q.reserve,
+			Remaining: max(q.limit-q.calls, 0),
+		}
+		quotasMu.Unlock()
+
+		usage.Exhausted = usage.Remaining == 0
+		usage.FallbackOnly = !usage.Exhausted && usage.Remaining <= usage.Reserve
+		usages = append(usages, usage)
+	}
+	return usages
+}
@@ -0,0 +1,113 @@
+package coin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"hermes-crypto-core/internal/models"
+)
+
+// QuoteMaxAge is how old a quote may be before it is rejected as stale
+var QuoteMaxAge = 30 * time.Second
+
+// QuoteMaxDeviation is the maximum relative deviation from the last known good price a quote may
+// have before it is rejected as implausible (0.1 = 10%)
+var QuoteMaxDeviation = 0.1
+
+// QuoteReferenceMaxAge is how long the last known good price is used as a reference. Older
+// references are ignored, so a real move while no prices were fetched does not lock out every quote.
+var QuoteReferenceMaxAge = 5 * time.Minute
+
+// Quote is a price reported by a provider, along with when the provider quoted it
+type Quote struct {
+	Price float64
+	Time  time.Time
+}
+
+// QuoteProvider is implemented by providers that know when their current price was quoted.
+// Prices of other providers are assumed to be quoted at the time they are fetched.
+type QuoteProvider interface {
+	PriceProvider
+	GetCurrentQuote(ctx context.Context, coin models.Coin) (*Quote, error)
+}
+
+var (
+	lastGoodMu sync.Mutex
+	lastGood   = map[string]Quote{}
+)
+
+// fetchQuote asks a provider for the current price of a coin through its circuit breaker and
+// validates the answer. Invalid quotes count as a failed call, so callers move on to the next
+// provider. Callers pass the prices they end up using to rememberQuote.
+func fetchQuote(ctx context.Context, provider PriceProvider, coin models.Coin) (*float64, error) {
+	return guardedCall(ctx, provider, func(ctx context.Context) (*float64, error) {
+		quote, err := getQuote(ctx, provider, coin)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateQuote(provider, coin, *quote); err != nil {
+			return nil, err
+		}
+		return &quote.Price, nil
+	})
+}
+
+func getQuote(ctx context.Context, provider PriceProvider, coin models.Coin) (*Quote, error) {
+	if quoteProvider, ok := provider.(QuoteProvider); ok {
+		return quoteProvider.GetCurrentQuote(ctx, coin)
+	}
+	rate, err := provider.GetCurrentExchangeRate(ctx, coin)
+	if err != nil {
+		return nil, err
+	}
+	if rate == nil {
+		return nil, models.ReturnError{ErrorMessage: "No price returned by " + provider.Name()}
+	}
+	return &Quote{Price: *rate, Time: time.Now()}, nil
+}
+
+// validateQuote rejects quotes that are not positive, are stale, or deviate too far from the last
+// known good price of the coin
+func validateQuote(provider PriceProvider, coin models.Coin, quote Quote) error {
+	if math.IsNaN(quote.Price) || math.IsInf(quote.Price, 0) || quote.Price <= 0 {
+		return models.ReturnError{ErrorMessage: fmt.Sprintf("Rejected price %v from %s: not a positive price", quote.Price, provider.Name())}
+	}
+	if age := time.Since(quote.Time); age > QuoteMaxAge {
+		return models.ReturnError{ErrorMessage: fmt.Sprintf("Rejected price from %s: quoted %v ago", provider.Name(), age.Round(time.Second))}
+	}
+
+	lastGoodMu.Lock()
+	reference, ok := lastGood[quoteKey(provider, coin)]
+	lastGoodMu.Unlock()
+
+	if ok && time.Since(reference.Time) <= QuoteReferenceMaxAge {
+		deviation := math.Abs(quote.Price-reference.Price) / reference.Price
+		if deviation > QuoteMaxDeviation {
+			return models.ReturnError{ErrorMessage: fmt.Sprintf("Rejected price %v from %s: deviates %.1f%% from last known price %v",
+				quote.Price, provider.Name(), deviation*100, reference.Price)}
+		}
+	}
+	return nil
+}
+
+// rememberQuote makes a price used for a result the last known good price of the coin
+func rememberQuote(provider PriceProvider, coin models.Coin, price float64) {
+	lastGoodMu.Lock()
+	defer lastGoodMu.Unlock()
+	lastGood[quoteKey(provider, coin)] = Quote{Price: price, Time: time.Now()}
+}
+
+// quoteKey keys references per quote currency, since providers quoting in USD and USDT differ slightly
+func quoteKey(provider PriceProvider, coin models.Coin) string {
+	return coin.Id + "/" + provider.QuoteCurrency()
+}
+
+// ResetQuotes forgets every last known good price
+func ResetQuotes() {
+	lastGoodMu.Lock()
+	defer lastGoodMu.Unlock()
+	lastGood = map[string]Quote{}
+}
@@ -0,0 +1,144 @@
+package coin
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"hermes-crypto-core/internal/models"
+)
+
+// SummaryCacheTTL is how long a market summary is served from the cache before providers are asked again
+var SummaryCacheTTL = time.Minute
+
+// SummaryProvider is a PriceProvider that can also provide 24 hour market statistics
+type SummaryProvider interface {
+	PriceProvider
+	// GetMarketSummary returns the statistics of the coin in the quote currency; statistics the
+	// provider does not know are left nil
+	GetMarketSummary(ctx context.Context, coin models.Coin) (*models.MarketSummary, error)
+}
+
+var (
+	summariesMu sync.Mutex
+	summaries   = map[string]models.MarketSummary{}
+	// summaryRequests collapses concurrent fetches of the same summary into a single call
+	summaryRequests singleflight.Group
+)
+
+// GetMarketSummary returns the 24 hour market statistics of the coin, served from the cache when
+// younger than SummaryCacheTTL. The first provider in the chain that answers sets the price and
+// currency; statistics it does not report are filled in by the providers after it.
+func GetMarketSummary(ctx context.Context, coin models.Coin) (*models.MarketSummary, error) {
+	summariesMu.Lock()
+	cached, ok := summaries[coin.Id]
+	summariesMu.Unlock()
+	if ok && time.Since(cached.QueryTime.Time) <= SummaryCacheTTL {
+		return &cached, nil
+	}
+
+	result, err, _ := summaryRequests.Do(coin.Id, func() (interface{}, error) {
+		// Shared with other callers, so the first caller going away must not cancel it; every
+		// provider call is still bounded by ProviderTimeout
+		summary, err := fetchMarketSummary(context.WithoutCancel(ctx), coin)
+		if err != nil {
+			return nil, err
+		}
+		summariesMu.Lock()
+		summaries[coin.Id] = *summary
+		summariesMu.Unlock()
+		return *summary, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	summary := result.(models.MarketSummary)
+	return &summary, nil
+}
+
+func fetchMarketSummary(ctx context.Context, coin models.Coin) (*models.MarketSummary, error) {
+	var summary *models.MarketSummary
+	for i, provider := range Providers {
+		summaryProvider, ok := provider.(SummaryProvider)
+		if !ok {
+			continue
+		}
+
+		// Filling in a summary is not a fallback, but standing in for a provider that failed is
+		callCtx := ctx
+		if summary == nil && i > 0 {
+			callCtx = withFallback(ctx)
+		}
+		next, err := guardedCall(callCtx, provider, func(ctx context.Context) (*models.MarketSummary, error) {
+			return summaryProvider.GetMarketSummary(ctx, coin)
+		})
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Summary provider %s failed: %v", provider.Name(), err)
+			continue
+		}
+
+		if summary == nil {
+			summary = next
+			summary.Providers = []string{provider.Name()}
+		} else if mergeSummary(ctx, summary, next) {
+			summary.Providers = append(summary.Providers, provider.Name())
+		}
+		if isCompleteSummary(summary) {
+			break
+		}
+	}
+
+	if summary == nil {
+		return nil, models.ReturnError{ErrorMessage: "Could not determine market summary"}
+	}
+	summary.Coin = coin.Id
+	summary.QueryTime = models.TimestampTime{Time: time.Now()}
+	return summary, nil
+}
+
+// mergeSummary fills the statistics missing from summary with those of next, converted to the
+// currency of summary, and reports whether anything was filled in
+func mergeSummary(ctx context.Context, summary, next *models.MarketSummary) bool {
+	merged := false
+	for _, field := range []struct{ into, from **float64 }{
+		{&summary.High24h, &next.High24h},
+		{&summary.Low24h, &next.Low24h},
+		{&summary.Volume24h, &next.Volume24h},
+		{&summary.MarketCap, &next.MarketCap},
+	} {
+		if *field.into != nil || *field.from == nil {
+			continue
+		}
+		value, err := Convert(ctx, **field.from, next.CoinValueCurrency, summary.CoinValueCurrency)
+		if err != nil {
+			log.Printf("Could not convert summary from %s to %s: %v", next.CoinValueCurrency, summary.CoinValueCurrency, err)
+			return merged
+		}
+		*field.into = value
+		merged = true
+	}
+	// A percentage does not need converting
+	if summary.PriceChangePercent24h == nil && next.PriceChangePercent24h != nil {
+		summary.PriceChangePercent24h = next.PriceChangePercent24h
+		merged = true
+	}
+	return merged
+}
+
+func isCompleteSummary(summary *models.MarketSummary) bool {
+	return summary.High24h != nil && summary.Low24h != nil && summary.Volume24h != nil &&
+		summary.PriceChangePercent24h != nil && summary.MarketCap != nil
+}
+
+// ResetSummaries drops every cached market summary
+func ResetSummaries() {
+	summariesMu.Lock()
+	summaries = map[string]models.MarketSummary{}
+	summariesMu.Unlock()
+}
@@ -2,6 +2,35 @@ package constants
 
 // Types of coins
 const COIN_TYPE_BTC string = "bitcoin"
+const COIN_TYPE_ETH string = "ethereum"
+const COIN_TYPE_SOL string = "solana"
+const COIN_TYPE_XRP string = "ripple"
+const COIN_TYPE_ADA string = "cardano"
+const COIN_TYPE_DOGE string = "dogecoin"
 
 // Currency types
 const COIN_CURRENCY_USD string = "USD"
+const COIN_CURRENCY_USDT string = "USDT"
+const COIN_CURRENCY_EUR string = "EUR"
+const COIN_CURRENCY_GBP string = "GBP"
+const COIN_CURRENCY_ZAR string = "ZAR"
+
+// Price providers
+const PROVIDER_BINANCE string = "binance"
+const PROVIDER_GECKO string = "gecko"
+const PROVIDER_BINANCE_STREAM string = "binance-stream"
+const PROVIDER_KRAKEN string = "kraken"
+const PROVIDER_COINBASE string = "coinbase"
+const PROVIDER_REPLAY string = "replay"
+
+// Provider name reported when a price is the consensus of several providers
+const PROVIDER_CONSENSUS string = "consensus"
+
+// Price modes
+const PRICE_MODE_FALLBACK string = "fallback"
+const PRICE_MODE_CONSENSUS string = "consensus"
+
+// Circuit breaker states of price providers
+const BREAKER_CLOSED string = "closed"
+const BREAKER_OPEN string = "open"
+const BREAKER_HALF_OPEN string = "half-open"
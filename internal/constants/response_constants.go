@@ -1,4 +1,31 @@
 package constants
 
 const USER_NOT_FOUND string = "User not found. Try another user identifier."
+const USER_CREDENTIALS_INVALID string = "Email or password is incorrect."
+const VOTE_NOT_FOUND string = "Vote not found. Try another vote identifier."
+const VOTE_CANCEL_TOO_LATE string = "Vote can no longer be cancelled."
+const VOTE_CANCEL_PRICE_MOVED string = "Vote can not be cancelled once the price has moved."
+const VOTE_IN_PROGRESS string = "User already has an ongoing vote"
+const VOTE_NOT_RESOLVED string = "Users last vote has not been resolved"
+const VOTE_STAKE_TOO_LOW string = "Stake is below the minimum stake."
+const VOTE_STAKE_TOO_HIGH string = "Stake is more than can be staked from your score."
+const VOTE_SCORE_CHANGED string = "Score or votes changed while updating the vote. Try again."
 const USER_VOTE_UPDATE_FAILED string = "Failed to update user vote(s)."
+const COIN_NOT_SUPPORTED string = "Coin is not supported. Try another coin identifier."
+const CURRENCY_NOT_SUPPORTED string = "Currency is not supported. Try USD, USDT, EUR, GBP or ZAR."
+const INTERVAL_NOT_SUPPORTED string = "Interval is not supported. Try 1s, 1m, 5m, 1h or 1d."
+const VOTE_DIRECTION_NOT_SUPPORTED string = "Direction is not supported. Try up, down or flat."
+const VOTE_ROUND_NOT_SUPPORTED string = "Round is not supported. Try 30s, 1m, 5m, 1h or 24h."
+const PRICE_SNAPSHOT_FAILED string = "Failed to record the price used for the vote."
+const PRICE_SNAPSHOT_NOT_FOUND string = "Price snapshot not found. Try another snapshot identifier."
+const AUTH_TOKEN_INVALID string = "Missing or invalid token. Sign in again to get a new token."
+const SOCKET_CHANNEL_NOT_SUPPORTED string = "Channel is not supported. Try votes or prices:<coin>."
+const SOCKET_ACTION_NOT_SUPPORTED string = "Action is not supported. Try subscribe or unsubscribe."
+const SOCKET_TOO_MANY_SUBSCRIPTIONS string = "Too many subscriptions. Unsubscribe from a channel first."
+const ADMIN_KEY_INVALID string = "Missing or invalid admin key."
+
+// Header carrying the token that identifies a user
+const AUTH_TOKEN_HEADER string = "X-Auth-Token"
+
+// Header carrying the key that unlocks the admin endpoints
+const ADMIN_KEY_HEADER string = "X-Admin-Key"
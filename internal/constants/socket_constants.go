@@ -0,0 +1,15 @@
+package constants
+
+// Channels of the live updates WebSocket
+const SOCKET_CHANNEL_VOTES string = "votes"
+const SOCKET_CHANNEL_PRICES string = "prices"
+
+// Actions clients can send over the live updates WebSocket
+const SOCKET_ACTION_SUBSCRIBE string = "subscribe"
+const SOCKET_ACTION_UNSUBSCRIBE string = "unsubscribe"
+
+// Message types sent to clients over the live updates WebSocket, besides the vote events
+const SOCKET_MESSAGE_SUBSCRIBED string = "subscribed"
+const SOCKET_MESSAGE_UNSUBSCRIBED string = "unsubscribed"
+const SOCKET_MESSAGE_PRICE string = "price"
+const SOCKET_MESSAGE_ERROR string = "error"
@@ -0,0 +1,32 @@
+package constants
+
+// Directions a vote can predict the price to move in. Flat predicts it stays within the flat tolerance.
+const VOTE_DIRECTION_UP string = "up"
+const VOTE_DIRECTION_DOWN string = "down"
+const VOTE_DIRECTION_FLAT string = "flat"
+
+// Vote rounds, chosen when a vote is placed. Votes placed without a round run for a minute.
+const VOTE_ROUND_30S string = "30s"
+const VOTE_ROUND_1M string = "1m"
+const VOTE_ROUND_5M string = "5m"
+const VOTE_ROUND_1H string = "1h"
+const VOTE_ROUND_24H string = "24h"
+const VOTE_ROUND_DEFAULT string = VOTE_ROUND_1M
+
+// Vote statuses. A vote is pending while it runs and resolvable once its window has passed; it then
+// ends as won, lost or tied, or as expired if it was checked too late to be scored fairly. Void votes
+// were cancelled.
+const VOTE_STATUS_PENDING string = "pending"
+const VOTE_STATUS_RESOLVABLE string = "resolvable"
+const VOTE_STATUS_WON string = "won"
+const VOTE_STATUS_LOST string = "lost"
+const VOTE_STATUS_TIED string = "tied"
+const VOTE_STATUS_EXPIRED string = "expired"
+const VOTE_STATUS_VOID string = "void"
+
+// Vote lifecycle events
+const VOTE_EVENT_CREATED string = "vote.created"
+const VOTE_EVENT_CANCELLED string = "vote.cancelled"
+const VOTE_EVENT_COUNTDOWN string = "vote.countdown"
+const VOTE_EVENT_RESOLVED string = "vote.resolved"
+const VOTE_EVENT_SCORE_CHANGED string = "score.changed"
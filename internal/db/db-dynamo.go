@@ -2,9 +2,13 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -23,6 +27,12 @@ var client *dynamodb.Client
 
 const tableName = "hermes-crypto-users"
 const emailIndex = "EmailIndex"
+const snapshotTableName = "hermes-crypto-price-snapshots"
+const coinIndex = "CoinIndex"
+const usageTableName = "hermes-crypto-provider-usage"
+
+// queryTimeout bounds every call made to DynamoDB on behalf of a request
+const queryTimeout = 5 * time.Second
 
 // Init initializes the DynamoDB client
 func Init() {
@@ -67,16 +77,32 @@ func Init() {
 	log.Println("DynamoDB client created successfully")
 
 	// Test connection before proceeding
-	hasTable := tableExists()
+	hasTable := tableExists(tableName)
 
 	log.Printf("Table %s exists: %v", tableName, hasTable)
 
 	if !hasTable {
 		createTableIfNotExists()
 	}
+
+	hasSnapshotTable := tableExists(snapshotTableName)
+
+	log.Printf("Table %s exists: %v", snapshotTableName, hasSnapshotTable)
+
+	if !hasSnapshotTable {
+		createSnapshotTableIfNotExists()
+	}
+
+	hasUsageTable := tableExists(usageTableName)
+
+	log.Printf("Table %s exists: %v", usageTableName, hasUsageTable)
+
+	if !hasUsageTable {
+		createUsageTableIfNotExists()
+	}
 }
 
-func tableExists() bool {
+func tableExists(name string) bool {
 	existingTables, err := client.ListTables(context.TODO(), &dynamodb.ListTablesInput{})
 	if err != nil {
 		return false
@@ -87,7 +113,7 @@ func tableExists() bool {
 	// the table name with the one we are looking for
 	for _, table := range existingTables.TableNames {
 		var tablePtr *string = &table
-		if *tablePtr == tableName {
+		if *tablePtr == name {
 			log.Println("Table already exists")
 			return true
 		}
@@ -159,6 +185,100 @@ func createTableIfNotExists() {
 	}
 }
 
+func createSnapshotTableIfNotExists() {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("Id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("Coin"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("FetchedAt"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("Id"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(coinIndex),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("Coin"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("FetchedAt"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+				ProvisionedThroughput: &types.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		TableName: aws.String(snapshotTableName),
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	})
+	if err != nil {
+		// If the table already exists, ignore the error
+		if _, ok := err.(*types.ResourceInUseException); !ok {
+			log.Fatalf("Error creating table: %v", err)
+		}
+	}
+}
+
+func createUsageTableIfNotExists() {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("Provider"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("Window"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("Provider"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("Window"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		TableName: aws.String(usageTableName),
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	})
+	if err != nil {
+		// If the table already exists, ignore the error
+		if _, ok := err.(*types.ResourceInUseException); !ok {
+			log.Fatalf("Error creating table: %v", err)
+		}
+	}
+}
+
 func buildUpdateExpression(av map[string]types.AttributeValue) *string {
 	var sets []string
 	for k := range av {
@@ -171,18 +291,12 @@ func buildUpdateExpression(av map[string]types.AttributeValue) *string {
 }
 
 // GetAllUsers retrieves all users from the DynamoDB table
-func (d *dynamoDB) GetAllUsers() ([]models.User, error) {
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	}
-
-	result, err := client.Scan(context.TODO(), input)
-	if err != nil {
-		return nil, err
-	}
-
+func (d *dynamoDB) GetAllUsers(ctx context.Context) ([]models.User, error) {
 	var users []models.User
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &users)
+	err := d.ScanUsers(ctx, func(page []models.User) error {
+		users = append(users, page...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -190,8 +304,42 @@ func (d *dynamoDB) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
+// ScanUsers reads every user in the DynamoDB table a page at a time, following LastEvaluatedKey
+// until the end of the table, and hands each page to handle. An error from handle stops the scan.
+func (d *dynamoDB) ScanUsers(ctx context.Context, handle func(users []models.User) error) error {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}
+
+	for {
+		// Every page gets the full timeout, however many pages the table has
+		pageCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+		result, err := client.Scan(pageCtx, input)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		var users []models.User
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &users); err != nil {
+			return err
+		}
+		if err := handle(users); err != nil {
+			return err
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
 // GetUserByID retrieves a specific user by Id
-func (d *dynamoDB) GetUserByID(id string) (*models.User, error) {
+func (d *dynamoDB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	// This is not an ideal solution - this should be optimized in future
 	input := &dynamodb.QueryInput{
 		TableName:              aws.String(tableName),
@@ -202,7 +350,7 @@ func (d *dynamoDB) GetUserByID(id string) (*models.User, error) {
 		Limit: aws.Int32(1), // We only need one item
 	}
 
-	result, err := client.Query(context.TODO(), input)
+	result, err := client.Query(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -221,7 +369,10 @@ func (d *dynamoDB) GetUserByID(id string) (*models.User, error) {
 }
 
 // GetUserByEmail retrieves a specific user by Email
-func (d *dynamoDB) GetUserByEmail(email string) (*models.User, error) {
+func (d *dynamoDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	input := &dynamodb.QueryInput{
 		TableName:              aws.String(tableName),
 		IndexName:              aws.String(emailIndex), // Using EmailIndex GSI
@@ -232,7 +383,7 @@ func (d *dynamoDB) GetUserByEmail(email string) (*models.User, error) {
 		Limit: aws.Int32(1), // We only need one item
 	}
 
-	result, err := d.client.Query(context.TODO(), input)
+	result, err := d.client.Query(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -251,7 +402,10 @@ func (d *dynamoDB) GetUserByEmail(email string) (*models.User, error) {
 }
 
 // CreateUser creates a new user entry in the DynamoDB table
-func (d *dynamoDB) CreateUser(user models.User) (*models.User, error) {
+func (d *dynamoDB) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	av, err := attributevalue.MarshalMap(user)
 	if err != nil {
 		return nil, err
@@ -263,7 +417,7 @@ func (d *dynamoDB) CreateUser(user models.User) (*models.User, error) {
 		ReturnValues: types.ReturnValueAllOld,
 	}
 
-	result, err := d.client.PutItem(context.TODO(), input)
+	result, err := d.client.PutItem(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -286,7 +440,10 @@ func (d *dynamoDB) CreateUser(user models.User) (*models.User, error) {
 }
 
 // UpdateUser updates an existing user in the DynamoDB table, using their user Id
-func (d *dynamoDB) UpdateUser(id string, user models.User, updateScore bool) (*models.User, error) {
+func (d *dynamoDB) UpdateUser(ctx context.Context, id string, user models.User, updateScore bool) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	av, err := attributevalue.MarshalMap(user)
 	if err != nil {
 		return nil, err
@@ -324,7 +481,92 @@ func (d *dynamoDB) UpdateUser(id string, user models.User, updateScore bool) (*m
 		ReturnValues:              types.ReturnValueAllNew,
 	}
 
-	result, err := d.client.UpdateItem(context.TODO(), input)
+	result, err := d.client.UpdateItem(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var updatedUser models.User
+	err = attributevalue.UnmarshalMap(result.Attributes, &updatedUser)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updatedUser, nil
+}
+
+// PlaceUserVote stores the votes and score of a user placing a vote, as long as the stored user still has
+// previousScore and one vote fewer. Points staked on the vote are reserved in the same write, so they can
+// never be staked twice or take the score below zero; ErrScoreChanged is returned if the user changed in
+// the meantime.
+func (d *dynamoDB) PlaceUserVote(ctx context.Context, user models.User, previousScore float64) (*models.User, error) {
+	count := len(user.Votes) - 1
+	// Users that never voted may have no votes stored, or null votes
+	condition := "Score = :previous AND (attribute_not_exists(Votes) OR attribute_type(Votes, :null) OR size(Votes) = :count)"
+	return d.updateUserVotes(ctx, user, previousScore, count, condition, nil, map[string]types.AttributeValue{
+		":null": &types.AttributeValueMemberS{Value: "NULL"},
+	})
+}
+
+// UpdateUserVote stores the votes and score of a user after the vote at index changed, as long as the
+// stored user still has previousScore, the same number of votes, and the vote at index still has
+// previousStatus. ErrScoreChanged is returned otherwise, so a write made from an outdated read never
+// overwrites another process resolving the vote or placing a new one.
+func (d *dynamoDB) UpdateUserVote(ctx context.Context, user models.User, index int, previousStatus string, previousScore float64) (*models.User, error) {
+	status := fmt.Sprintf("Votes[%d].#Status", index)
+	condition := "Score = :previous AND size(Votes) = :count AND " + status + " = :status"
+	// Votes placed before statuses were stored may have none
+	if previousStatus == "" {
+		condition = "Score = :previous AND size(Votes) = :count AND (attribute_not_exists(" + status + ") OR " + status + " = :status)"
+	}
+	// Status is a reserved word, so it is referred to by name
+	return d.updateUserVotes(ctx, user, previousScore, len(user.Votes), condition, map[string]string{"#Status": "Status"}, map[string]types.AttributeValue{
+		":status": &types.AttributeValueMemberS{Value: previousStatus},
+	})
+}
+
+// updateUserVotes sets the votes and score of a user if the condition holds. The condition can refer to
+// :previous, the previous score, and :count, the previous number of votes, along with the given names
+// and values.
+func (d *dynamoDB) updateUserVotes(ctx context.Context, user models.User, previousScore float64, count int, condition string, names map[string]string, values map[string]types.AttributeValue) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	votes, err := attributevalue.Marshal(user.Votes)
+	if err != nil {
+		return nil, err
+	}
+	score, err := attributevalue.Marshal(user.Score)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := attributevalue.Marshal(previousScore)
+	if err != nil {
+		return nil, err
+	}
+	values[":votes"] = votes
+	values[":score"] = score
+	values[":previous"] = previous
+	values[":count"] = &types.AttributeValueMemberN{Value: strconv.Itoa(count)}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"Id":    &types.AttributeValueMemberS{Value: user.Id},
+			"Email": &types.AttributeValueMemberS{Value: user.Email},
+		},
+		UpdateExpression:          aws.String("SET Votes = :votes, Score = :score"),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	}
+
+	result, err := d.client.UpdateItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil, ErrScoreChanged
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -338,8 +580,38 @@ func (d *dynamoDB) UpdateUser(id string, user models.User, updateScore bool) (*m
 	return &updatedUser, nil
 }
 
+// SetUserPassword stores the password hash of a user that has none yet, leaving the rest of the user as
+// it is. ErrPasswordSet is returned if the user already has one, so a password can only be claimed once.
+func (d *dynamoDB) SetUserPassword(ctx context.Context, user models.User, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"Id":    &types.AttributeValueMemberS{Value: user.Id},
+			"Email": &types.AttributeValueMemberS{Value: user.Email},
+		},
+		UpdateExpression:    aws.String("SET PasswordHash = :hash"),
+		ConditionExpression: aws.String("attribute_exists(Id) AND attribute_not_exists(PasswordHash)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":hash": &types.AttributeValueMemberS{Value: passwordHash},
+		},
+	}
+
+	_, err := d.client.UpdateItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrPasswordSet
+	}
+	return err
+}
+
 // DeleteUser removes a user from the DynamoDB table
-func (d *dynamoDB) DeleteUser(id string) error {
+func (d *dynamoDB) DeleteUser(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	input := &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
@@ -347,6 +619,157 @@ func (d *dynamoDB) DeleteUser(id string) error {
 		},
 	}
 
-	_, err := client.DeleteItem(context.TODO(), input)
+	_, err := client.DeleteItem(ctx, input)
 	return err
 }
+
+// CreatePriceSnapshot stores a fetched price in the DynamoDB snapshot table
+func (d *dynamoDB) CreatePriceSnapshot(ctx context.Context, snapshot models.PriceSnapshot) (*models.PriceSnapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	av, err := attributevalue.MarshalMap(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(snapshotTableName),
+		Item:      av,
+		// Snapshots are immutable, so never overwrite an existing one
+		ConditionExpression: aws.String("attribute_not_exists(Id)"),
+	}
+
+	_, err = d.client.PutItem(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// GetPriceSnapshotByID retrieves a specific price snapshot by Id
+func (d *dynamoDB) GetPriceSnapshotByID(ctx context.Context, id string) (*models.PriceSnapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(snapshotTableName),
+		Key: map[string]types.AttributeValue{
+			"Id": &types.AttributeValueMemberS{Value: id},
+		},
+	}
+
+	result, err := d.client.GetItem(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Item) == 0 {
+		return nil, nil // Snapshot not found
+	}
+
+	var snapshot models.PriceSnapshot
+	err = attributevalue.UnmarshalMap(result.Item, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// GetPriceSnapshotsBetween retrieves the price snapshots of a coin fetched between two times, inclusive
+func (d *dynamoDB) GetPriceSnapshotsBetween(ctx context.Context, coin string, from time.Time, to time.Time) ([]models.PriceSnapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	// Fetch times are stored as RFC 3339 strings in UTC, which sort in time order
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(snapshotTableName),
+		IndexName:              aws.String(coinIndex),
+		KeyConditionExpression: aws.String("Coin = :Coin AND FetchedAt BETWEEN :From AND :To"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":Coin": &types.AttributeValueMemberS{Value: coin},
+			":From": &types.AttributeValueMemberS{Value: from.UTC().Format(time.RFC3339)},
+			":To":   &types.AttributeValueMemberS{Value: to.UTC().Format(time.RFC3339)},
+		},
+	}
+
+	result, err := d.client.Query(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []models.PriceSnapshot
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// IncrementProviderUsage atomically counts a call to a provider in a billing window and returns the new count
+func (d *dynamoDB) IncrementProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(usageTableName),
+		Key: map[string]types.AttributeValue{
+			"Provider": &types.AttributeValueMemberS{Value: provider},
+			"Window":   &types.AttributeValueMemberS{Value: window},
+		},
+		// ADD creates the counter on the first call of a window, and is safe across Lambda instances
+		UpdateExpression: aws.String("ADD Calls :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	}
+
+	result, err := d.client.UpdateItem(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage models.ProviderUsage
+	err = attributevalue.UnmarshalMap(result.Attributes, &usage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// GetProviderUsage retrieves the calls made to a provider in a billing window
+func (d *dynamoDB) GetProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(usageTableName),
+		Key: map[string]types.AttributeValue{
+			"Provider": &types.AttributeValueMemberS{Value: provider},
+			"Window":   &types.AttributeValueMemberS{Value: window},
+		},
+	}
+
+	result, err := d.client.GetItem(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// No calls have been made in the window yet
+	usage := models.ProviderUsage{Provider: provider, Window: window}
+	if len(result.Item) == 0 {
+		return &usage, nil
+	}
+
+	err = attributevalue.UnmarshalMap(result.Item, &usage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
@@ -1,14 +1,38 @@
 package db
 
-import "hermes-crypto-core/internal/models"
+import (
+	"context"
+	"errors"
+	"time"
 
+	"hermes-crypto-core/internal/models"
+)
+
+// DBInterface is the storage used by the API. Every call takes the context of the request it is made
+// for, so that it is abandoned when the request is.
 type DBInterface interface {
-	GetAllUsers() ([]models.User, error)
-	GetUserByID(id string) (*models.User, error)
-	GetUserByEmail(email string) (*models.User, error)
-	CreateUser(user models.User) (*models.User, error)
-	UpdateUser(id string, user models.User, updateScore bool) (*models.User, error)
-	DeleteUser(id string) error
+	GetAllUsers(ctx context.Context) ([]models.User, error)
+	ScanUsers(ctx context.Context, handle func(users []models.User) error) error
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	CreateUser(ctx context.Context, user models.User) (*models.User, error)
+	UpdateUser(ctx context.Context, id string, user models.User, updateScore bool) (*models.User, error)
+	SetUserPassword(ctx context.Context, user models.User, passwordHash string) error
+	PlaceUserVote(ctx context.Context, user models.User, previousScore float64) (*models.User, error)
+	UpdateUserVote(ctx context.Context, user models.User, index int, previousStatus string, previousScore float64) (*models.User, error)
+	DeleteUser(ctx context.Context, id string) error
+	CreatePriceSnapshot(ctx context.Context, snapshot models.PriceSnapshot) (*models.PriceSnapshot, error)
+	GetPriceSnapshotByID(ctx context.Context, id string) (*models.PriceSnapshot, error)
+	GetPriceSnapshotsBetween(ctx context.Context, coin string, from time.Time, to time.Time) ([]models.PriceSnapshot, error)
+	IncrementProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error)
+	GetProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error)
 }
 
 var DB DBInterface
+
+// ErrScoreChanged is returned by PlaceUserVote and UpdateUserVote when the stored score or votes are no
+// longer the ones the write was made against
+var ErrScoreChanged = errors.New("score or votes changed since they were read")
+
+// ErrPasswordSet is returned by SetUserPassword when the user already has a password
+var ErrPasswordSet = errors.New("user already has a password")
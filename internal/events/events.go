@@ -0,0 +1,56 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"hermes-crypto-core/internal/models"
+)
+
+// subscriberBuffer is the number of events a subscriber may fall behind before events are dropped
+const subscriberBuffer = 32
+
+var (
+	mu          sync.Mutex
+	subscribers = map[string]map[chan models.VoteEvent]struct{}{}
+)
+
+// Publish sends a vote event to every subscriber of the user. Events only reach subscribers in
+// this process, so nothing is delivered when running behind the Lambda proxy.
+func Publish(event models.VoteEvent) {
+	if event.Time.IsZero() {
+		event.Time = models.TimestampTime{Time: time.Now().UTC()}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for subscriber := range subscribers[event.UserId] {
+		select {
+		case subscriber <- event:
+		default:
+			// A slow subscriber misses the event rather than holding up the request publishing it
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the vote events of a user. Call unsubscribe when done.
+func Subscribe(userId string) (<-chan models.VoteEvent, func()) {
+	subscriber := make(chan models.VoteEvent, subscriberBuffer)
+
+	mu.Lock()
+	if subscribers[userId] == nil {
+		subscribers[userId] = map[chan models.VoteEvent]struct{}{}
+	}
+	subscribers[userId][subscriber] = struct{}{}
+	mu.Unlock()
+
+	return subscriber, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subscribers[userId], subscriber)
+		if len(subscribers[userId]) == 0 {
+			delete(subscribers, userId)
+		}
+	}
+}
@@ -2,41 +2,135 @@ package coins
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"hermes-crypto-core/internal/coin"
 	con "hermes-crypto-core/internal/constants"
-	"hermes-crypto-core/internal/models"
+	"hermes-crypto-core/internal/db"
 )
 
-// GetCurrentBTCCoinValue handles GET requests to retrieve the value for the current Bitcoin coin
-func GetCurrentBTCCoinValueInUSD(c *gin.Context) {
-	currentExchangeRate, err := coin.BinanceGetCurrentExchangeRate()
+// GetCoins handles GET requests to retrieve the catalog of coins that can be predicted on
+func GetCoins(c *gin.Context) {
+	c.JSON(http.StatusOK, coin.SupportedCoins)
+}
+
+// GetCoinSummary handles GET requests to retrieve the 24 hour market statistics of the specified coin
+func GetCoinSummary(c *gin.Context) {
+	supportedCoin, ok := coin.GetSupportedCoin(c.Param("coin"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": con.COIN_NOT_SUPPORTED})
+		return
+	}
+
+	summary, err := coin.GetMarketSummary(c.Request.Context(), *supportedCoin)
 	if err != nil {
-		geckoExchangeRate, err := coin.GeckoGetCurrentExchangeRate()
-		if err != nil {
-			c.JSON(http.StatusFailedDependency, gin.H{"error": "Could not determine current exchange rate", "message": err.Error()})
+		c.JSON(http.StatusFailedDependency, gin.H{"error": "Could not determine market summary", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// GetCurrentCoinValue handles GET requests to retrieve the current value of the specified (by id or symbol) coin,
+// optionally in the currency given by the currency query parameter
+func GetCurrentCoinValue(c *gin.Context) {
+	supportedCoin, ok := coin.GetSupportedCoin(c.Param("coin"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": con.COIN_NOT_SUPPORTED})
+		return
+	}
+
+	// Without a requested currency we return the price in whatever the provider quotes in
+	currency := c.Query("currency")
+	if currency != "" {
+		supportedCurrency, ok := coin.GetSupportedCurrency(currency)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": con.CURRENCY_NOT_SUPPORTED})
 			return
 		}
+		currency = supportedCurrency
+	}
+
+	coinResult, err := coin.GetCurrentExchangeRate(c.Request.Context(), *supportedCoin, currency)
+	if err != nil {
+		c.JSON(http.StatusFailedDependency, gin.H{"error": "Could not determine current exchange rate", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coinResult)
+}
+
+// GetCoinHistory handles GET requests to retrieve historical candles for the specified coin. The interval,
+// from, to and limit query parameters are optional; follow next_from in the response to page through long ranges.
+func GetCoinHistory(c *gin.Context) {
+	supportedCoin, ok := coin.GetSupportedCoin(c.Param("coin"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": con.COIN_NOT_SUPPORTED})
+		return
+	}
 
-		coinResult := models.CoinResult{
-			Coin:              con.COIN_TYPE_BTC,
-			CoinValue:         *geckoExchangeRate,
-			CoinValueCurrency: con.COIN_CURRENCY_USD,
-			QueryTime:         models.TimestampTime{Time: time.Now()},
+	interval := c.DefaultQuery("interval", "1m")
+	length, ok := coin.HistoryIntervals[interval]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": con.INTERVAL_NOT_SUPPORTED})
+		return
+	}
+
+	limit := coin.DefaultHistoryLimit
+	if c.Query("limit") != "" {
+		parsedLimit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || parsedLimit < 1 || parsedLimit > coin.MaxHistoryLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(coin.MaxHistoryLimit)})
+			return
 		}
+		limit = parsedLimit
+	}
 
-		c.JSON(http.StatusOK, coinResult)
+	to := time.Now()
+	if c.Query("to") != "" {
+		parsedTo, err := time.Parse(time.RFC3339, c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC3339 timestamp", "message": err.Error()})
+			return
+		}
+		to = parsedTo
+	}
+	from := to.Add(-time.Duration(limit) * length)
+	if c.Query("from") != "" {
+		parsedFrom, err := time.Parse(time.RFC3339, c.Query("from"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC3339 timestamp", "message": err.Error()})
+			return
+		}
+		from = parsedFrom
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
 	}
 
-	coinResult := models.CoinResult{
-		Coin:              con.COIN_TYPE_BTC,
-		CoinValue:         *currentExchangeRate,
-		CoinValueCurrency: con.COIN_CURRENCY_USD,
-		QueryTime:         models.TimestampTime{Time: time.Now()},
+	history, err := coin.GetHistory(c.Request.Context(), *supportedCoin, interval, from, to, limit)
+	if err != nil {
+		c.JSON(http.StatusFailedDependency, gin.H{"error": "Could not determine price history", "message": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, coinResult)
+	c.JSON(http.StatusOK, history)
+}
+
+// GetPriceSnapshot handles GET requests to retrieve a recorded price snapshot (by id), to audit the price used for a vote
+func GetPriceSnapshot(c *gin.Context) {
+	snapshot, err := db.DB.GetPriceSnapshotByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price snapshot", "message": err.Error()})
+		return
+	}
+	if snapshot == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": con.PRICE_SNAPSHOT_NOT_FOUND})
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
 }
@@ -0,0 +1,20 @@
+package coins
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"hermes-crypto-core/internal/coin"
+)
+
+// ProviderHealthCheck handles GET requests to retrieve the circuit breaker state of every price provider
+func ProviderHealthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, coin.GetProviderHealth())
+}
+
+// ProviderQuotaCheck handles GET requests to retrieve the calls made to every metered price provider
+// in the current billing window, and how many are left
+func ProviderQuotaCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, coin.GetQuotaUsage(c.Request.Context()))
+}
@@ -0,0 +1,85 @@
+package coins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hermes-crypto-core/internal/coin"
+	con "hermes-crypto-core/internal/constants"
+)
+
+// StreamHeartbeat is how often a heartbeat event is sent on an idle price stream, so proxies
+// keep the connection open and clients can tell a quiet market from a dead connection
+var StreamHeartbeat = 15 * time.Second
+
+// StreamCoinValue handles GET requests to stream the price of the specified coin as Server-Sent
+// Events, optionally in the currency given by the currency query parameter. Clients reconnecting
+// with a Last-Event-ID header are sent the prices they missed, as far as they are still remembered.
+func StreamCoinValue(c *gin.Context) {
+	supportedCoin, ok := coin.GetSupportedCoin(c.Param("coin"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": con.COIN_NOT_SUPPORTED})
+		return
+	}
+
+	currency := c.Query("currency")
+	if currency != "" {
+		supportedCurrency, ok := coin.GetSupportedCurrency(currency)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": con.CURRENCY_NOT_SUPPORTED})
+			return
+		}
+		currency = supportedCurrency
+	}
+
+	// An unparseable id is treated as a new client
+	lastEventId, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+
+	backlog, ticks, unsubscribe := coin.SubscribePrices(*supportedCoin, currency, lastEventId)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop reverse proxies like nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, tick := range backlog {
+		writePriceEvent(c.Writer, tick)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case tick, ok := <-ticks:
+			// The client fell behind; it reconnects and resumes from the last event it received
+			if !ok {
+				return
+			}
+			writePriceEvent(c.Writer, tick)
+		case now := <-heartbeat.C:
+			fmt.Fprintf(c.Writer, "event: heartbeat\ndata: {\"time\":%q}\n\n", now.UTC().Format(time.RFC3339))
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writePriceEvent(w io.Writer, tick coin.PriceTick) {
+	data, err := json.Marshal(tick.Result)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: price\ndata: %s\n\n", tick.Id, data)
+}
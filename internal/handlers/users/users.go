@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"hermes-crypto-core/internal/auth"
 	con "hermes-crypto-core/internal/constants"
 	"hermes-crypto-core/internal/db"
 	"hermes-crypto-core/internal/models"
@@ -14,7 +15,7 @@ import (
 
 // GetUsers handles GET requests to retrieve all users
 func GetUsers(c *gin.Context) {
-	users, err := db.DB.GetAllUsers()
+	users, err := db.DB.GetAllUsers(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users", "message": err.Error()})
 		return
@@ -25,7 +26,7 @@ func GetUsers(c *gin.Context) {
 // GetUser handles GET requests to retrieve a specific user (by id)
 func GetUser(c *gin.Context) {
 	id := c.Param("id")
-	user, err := db.DB.GetUserByID(id)
+	user, err := db.DB.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": con.USER_NOT_FOUND, "message": err.Error()})
 		return
@@ -33,43 +34,78 @@ func GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-// CreateUser handles POST requests to create a new user
+// CreateUser handles POST requests to create a new user, or to sign in as the existing user with the
+// same email. Either way a token identifying the user is returned in the X-Auth-Token header. Users
+// with a password have to give it to sign in; users without one set theirs by signing in with it.
 func CreateUser(c *gin.Context) {
-	var newUser models.User
+	var request models.UserRequest
 	id := uuid.New() // Generate a new UUID for the user
-	if err := c.ShouldBindJSON(&newUser); err != nil {
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	log.Printf("Checking to see if user already exists by email: %v", newUser.Email)
+	log.Printf("Checking to see if user already exists by email: %v", request.Email)
 	// Check if user already exists by email
-	user, err := db.DB.GetUserByEmail(newUser.Email)
+	user, err := db.DB.GetUserByEmail(c.Request.Context(), request.Email)
 	if err != nil {
 		log.Printf("Error getting user by email: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing user"})
+		return
 	}
 
-	log.Printf("User: %v", user)
-
 	// If user already exists, return the existing user
 	if user != nil {
+		if !signIn(c, user, request.Password) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": con.USER_CREDENTIALS_INVALID})
+			return
+		}
+		c.Header(con.AUTH_TOKEN_HEADER, auth.IssueToken(user.Id))
 		c.JSON(http.StatusOK, user)
 		return
 	}
-	log.Printf("We have no user, thus we move")
 
-	// If user does not exist, create a new user
-	newUser.Id = id.String()
-	newUser.Score = 0
-	createdUser, err := db.DB.CreateUser(newUser)
+	// If user does not exist, create a new user, with a password if one was given
+	newUser := models.User{Id: id.String(), Name: request.Name, Email: request.Email}
+	if request.Password != "" {
+		newUser.PasswordHash, err = auth.HashPassword(request.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	createdUser, err := db.DB.CreateUser(c.Request.Context(), newUser)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user", "message": err.Error()})
 		return
 	}
+	c.Header(con.AUTH_TOKEN_HEADER, auth.IssueToken(createdUser.Id))
 	c.JSON(http.StatusCreated, createdUser)
 }
 
+// signIn reports whether the password lets the caller sign in as an existing user: it has to match
+// the user's password, if they have one. Users created before passwords can sign in without one, and
+// the first password they sign in with becomes theirs.
+func signIn(c *gin.Context, user *models.User, password string) bool {
+	if user.PasswordHash != "" {
+		return auth.CheckPassword(user.PasswordHash, password)
+	}
+	if password == "" {
+		return true
+	}
+
+	passwordHash, err := auth.HashPassword(password)
+	if err != nil {
+		return false
+	}
+	// Someone else may have claimed the password in the meantime, then theirs has to be given
+	if err := db.DB.SetUserPassword(c.Request.Context(), *user, passwordHash); err != nil {
+		log.Printf("Could not set the password of user %s: %v", user.Id, err)
+		return false
+	}
+	return true
+}
+
 // UpdateUser handles PUT requests to update an existing user
 func UpdateUser(c *gin.Context) {
 	id := c.Param("id")
@@ -79,7 +115,7 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	user, err := db.DB.UpdateUser(id, updatedUser, false)
+	user, err := db.DB.UpdateUser(c.Request.Context(), id, updatedUser, false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user", "message": err.Error()})
 		return
@@ -90,7 +126,7 @@ func UpdateUser(c *gin.Context) {
 // DeleteUser handles DELETE requests to remove a user
 func DeleteUser(c *gin.Context) {
 	id := c.Param("id")
-	if err := db.DB.DeleteUser(id); err != nil {
+	if err := db.DB.DeleteUser(c.Request.Context(), id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user", "message": err.Error()})
 		return
 	}
@@ -2,6 +2,7 @@ package users
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -13,6 +14,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"hermes-crypto-core/internal/auth"
+	"hermes-crypto-core/internal/coin"
 	con "hermes-crypto-core/internal/constants"
 	"hermes-crypto-core/internal/db"
 	"hermes-crypto-core/internal/models"
@@ -23,17 +26,22 @@ type MockDB struct {
 	mock.Mock
 }
 
-func (m *MockDB) GetAllUsers() ([]models.User, error) {
+func (m *MockDB) GetAllUsers(ctx context.Context) ([]models.User, error) {
 	args := m.Called()
 	return args.Get(0).([]models.User), args.Error(1)
 }
 
-func (m *MockDB) GetUserByID(id string) (*models.User, error) {
+func (m *MockDB) ScanUsers(ctx context.Context, handle func(users []models.User) error) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockDB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
 	args := m.Called(id)
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockDB) GetUserByEmail(email string) (*models.User, error) {
+func (m *MockDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	args := m.Called(email)
 	if args.Get(0) == nil {
 		return nil, nil
@@ -41,21 +49,94 @@ func (m *MockDB) GetUserByEmail(email string) (*models.User, error) {
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockDB) CreateUser(user models.User) (*models.User, error) {
+func (m *MockDB) SetUserPassword(ctx context.Context, user models.User, passwordHash string) error {
+	args := m.Called(user, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockDB) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
 	args := m.Called(user)
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockDB) UpdateUser(id string, user models.User, updateScore bool) (*models.User, error) {
+func (m *MockDB) UpdateUser(ctx context.Context, id string, user models.User, updateScore bool) (*models.User, error) {
 	args := m.Called(id, user, updateScore)
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockDB) DeleteUser(id string) error {
+func (m *MockDB) PlaceUserVote(ctx context.Context, user models.User, previousScore float64) (*models.User, error) {
+	args := m.Called(user, previousScore)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockDB) UpdateUserVote(ctx context.Context, user models.User, index int, previousStatus string, previousScore float64) (*models.User, error) {
+	args := m.Called(user, index, previousStatus, previousScore)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockDB) DeleteUser(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
+func (m *MockDB) CreatePriceSnapshot(ctx context.Context, snapshot models.PriceSnapshot) (*models.PriceSnapshot, error) {
+	args := m.Called(snapshot)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PriceSnapshot), args.Error(1)
+}
+
+func (m *MockDB) GetPriceSnapshotByID(ctx context.Context, id string) (*models.PriceSnapshot, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PriceSnapshot), args.Error(1)
+}
+
+func (m *MockDB) GetPriceSnapshotsBetween(ctx context.Context, coin string, from time.Time, to time.Time) ([]models.PriceSnapshot, error) {
+	args := m.Called(coin, from, to)
+	return args.Get(0).([]models.PriceSnapshot), args.Error(1)
+}
+
+func (m *MockDB) IncrementProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error) {
+	args := m.Called(provider, window)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ProviderUsage), args.Error(1)
+}
+
+func (m *MockDB) GetProviderUsage(ctx context.Context, provider string, window string) (*models.ProviderUsage, error) {
+	args := m.Called(provider, window)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ProviderUsage), args.Error(1)
+}
+
+// MockPriceProvider is a mock of a coin price provider
+type MockPriceProvider struct {
+	mock.Mock
+}
+
+func (m *MockPriceProvider) Name() string {
+	return "mock"
+}
+
+func (m *MockPriceProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USD
+}
+
+func (m *MockPriceProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	args := m.Called(coin.Id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*float64), args.Error(1)
+}
+
 func setupTestRouter() (*gin.Engine, *MockDB) {
 	r := gin.Default()
 	mockDB := new(MockDB)
@@ -63,6 +144,16 @@ func setupTestRouter() (*gin.Engine, *MockDB) {
 	return r, mockDB
 }
 
+func setupTestProvider(price float64) *MockPriceProvider {
+	mockProvider := new(MockPriceProvider)
+	mockProvider.On("GetCurrentExchangeRate", mock.Anything).Return(&price, nil)
+	coin.Providers = []coin.PriceProvider{mockProvider}
+	coin.ResetCache()
+	coin.ResetBreakers()
+	coin.ResetQuotes()
+	return mockProvider
+}
+
 // Users Tests
 func TestGetUsers(t *testing.T) {
 	r, mockDB := setupTestRouter()
@@ -70,7 +161,7 @@ func TestGetUsers(t *testing.T) {
 
 	mockUsers := []models.User{
 		{Id: "1", Name: "Test User", Email: "test@test.com", Votes: []models.Vote{
-			{VoteDirection: "up", CoinValue: 0.5, CoinValueAtVote: 0.5, CoinValueCurrency: con.COIN_CURRENCY_USD, VoteCoin: con.COIN_TYPE_BTC, VoteDateTime: models.TimestampTime{time.Time{}}}}}}
+			{VoteDirection: "up", CoinValue: 0.5, CoinValueAtVote: 0.5, CoinValueCurrency: con.COIN_CURRENCY_USD, VoteCoin: con.COIN_TYPE_BTC, VoteDateTime: models.TimestampTime{Time: time.Time{}}}}}}
 	mockDB.On("GetAllUsers").Return(mockUsers, nil)
 
 	w := httptest.NewRecorder()
@@ -102,24 +193,124 @@ func TestGetUser(t *testing.T) {
 	assert.Equal(t, *mockUser, response)
 }
 
+func TestCreateUser(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	r.POST("/users", CreateUser)
+
+	mockDB.On("GetUserByEmail", "new@test.com").Return(nil, nil)
+	var createdUser models.User
+	mockDB.On("CreateUser", mock.AnythingOfType("models.User")).Run(func(args mock.Arguments) {
+		createdUser = args.Get(0).(models.User)
+	}).Return(&models.User{Id: "1", Name: "New User", Email: "new@test.com"}, nil)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"name": "New User", "email": "new@test.com", "password": "secret", "score": 100})
+	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, w.Header().Get(con.AUTH_TOKEN_HEADER))
+	// Only the hash of the password is stored, and the score always starts at zero
+	assert.NotEqual(t, "secret", createdUser.PasswordHash)
+	assert.True(t, auth.CheckPassword(createdUser.PasswordHash, "secret"))
+	assert.Zero(t, createdUser.Score)
+	assert.NotContains(t, w.Body.String(), createdUser.PasswordHash)
+
+	// A password is optional
+	w = httptest.NewRecorder()
+	body, _ = json.Marshal(gin.H{"name": "New User", "email": "new@test.com"})
+	req, _ = http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, w.Header().Get(con.AUTH_TOKEN_HEADER))
+	assert.Empty(t, createdUser.PasswordHash)
+}
+
 func TestCreateExistingUserByEmail(t *testing.T) {
 	r, mockDB := setupTestRouter()
 	r.POST("/users", CreateUser)
 
-	newUser := models.User{Id: "188912334", Name: "New User", Email: "test@test.com"}
-	mockDB.On("GetUserByEmail", newUser.Email).Return(&newUser, nil)
-	mockDB.On("CreateUser", newUser).Return(&newUser, nil)
+	passwordHash, _ := auth.HashPassword("secret")
+	existingUser := models.User{Id: "188912334", Name: "New User", Email: "test@test.com", PasswordHash: passwordHash}
+	mockDB.On("GetUserByEmail", existingUser.Email).Return(&existingUser, nil)
 
 	w := httptest.NewRecorder()
-	body, _ := json.Marshal(newUser)
+	body, _ := json.Marshal(gin.H{"name": "New User", "email": "test@test.com", "password": "secret"})
 	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
+	assert.NotEmpty(t, w.Header().Get(con.AUTH_TOKEN_HEADER))
 	var response models.User
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.Nil(t, err)
-	assert.Equal(t, newUser.Id, response.Id)
+	assert.Equal(t, existingUser.Id, response.Id)
+	mockDB.AssertNotCalled(t, "CreateUser", mock.Anything)
+}
+
+func TestCreateExistingUserWithWrongPassword(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	r.POST("/users", CreateUser)
+
+	passwordHash, _ := auth.HashPassword("secret")
+	mockDB.On("GetUserByEmail", "test@test.com").Return(&models.User{Id: "1", Email: "test@test.com", PasswordHash: passwordHash}, nil)
+
+	for _, request := range []gin.H{{"email": "test@test.com", "password": "wrong"}, {"email": "test@test.com"}} {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(request)
+		req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, w.Header().Get(con.AUTH_TOKEN_HEADER))
+	}
+	mockDB.AssertNotCalled(t, "CreateUser", mock.Anything)
+	mockDB.AssertNotCalled(t, "SetUserPassword", mock.Anything, mock.Anything)
+}
+
+func TestCreateExistingUserWithoutPassword(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	r.POST("/users", CreateUser)
+
+	// Users created before passwords sign in as before
+	legacyUser := &models.User{Id: "1", Email: "legacy@test.com"}
+	mockDB.On("GetUserByEmail", "legacy@test.com").Return(legacyUser, nil)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"email": "legacy@test.com"})
+	req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get(con.AUTH_TOKEN_HEADER))
+	mockDB.AssertNotCalled(t, "SetUserPassword", mock.Anything, mock.Anything)
+
+	// Signing in with a password sets it
+	var passwordHash string
+	mockDB.On("SetUserPassword", *legacyUser, mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+		passwordHash = args.String(1)
+	}).Return(nil).Once()
+
+	w = httptest.NewRecorder()
+	body, _ = json.Marshal(gin.H{"email": "legacy@test.com", "password": "secret"})
+	req, _ = http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get(con.AUTH_TOKEN_HEADER))
+	assert.True(t, auth.CheckPassword(passwordHash, "secret"))
+
+	// Unless someone else set one in the meantime
+	mockDB.On("SetUserPassword", *legacyUser, mock.AnythingOfType("string")).Return(db.ErrPasswordSet).Once()
+
+	w = httptest.NewRecorder()
+	body, _ = json.Marshal(gin.H{"email": "legacy@test.com", "password": "other"})
+	req, _ = http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Header().Get(con.AUTH_TOKEN_HEADER))
 }
 
 func TestUpdateUser(t *testing.T) {
@@ -203,10 +394,12 @@ func TestGetUserLastVoteResult(t *testing.T) {
 
 func TestGetUserLastVoteResultNoValue(t *testing.T) {
 	r, mockDB := setupTestRouter()
+	setupTestProvider(58804.0)
 	r.GET("/users/:id/votes/result", GetLastUserVoteResult)
 
 	voteDateTime1, _ := time.Parse(time.RFC3339, "2023-10-12T07:20:50.52Z")
-	voteDateTime2, _ := time.Parse(time.RFC3339, "2024-01-01T19:30:50.52Z")
+	// The window has just passed, so the vote is still within the tolerance for resolving it
+	voteDateTime2 := time.Now().Add(-time.Minute - 5*time.Second)
 	mockUser := &models.User{
 		Id:    "18890123000123",
 		Name:  "Test User",
@@ -218,7 +411,9 @@ func TestGetUserLastVoteResultNoValue(t *testing.T) {
 		},
 	}
 	mockDB.On("GetUserByID", "18890123000123").Return(mockUser, nil)
-	mockDB.On("UpdateUser", "18890123000123", mock.AnythingOfType("models.User"), true).Return(mockUser, nil)
+	// The vote was placed before statuses were stored
+	mockDB.On("UpdateUserVote", mock.AnythingOfType("models.User"), 1, "", 0.0).Return(mockUser, nil)
+	mockDB.On("CreatePriceSnapshot", mock.AnythingOfType("models.PriceSnapshot")).Return(&models.PriceSnapshot{Id: "snapshot-1"}, nil)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/users/18890123000123/votes/result", nil)
@@ -234,7 +429,307 @@ func TestGetUserLastVoteResultNoValue(t *testing.T) {
 		var response models.Vote
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 		assert.Nil(t, err)
-		assert.NotEqual(t, 58804.0, response.CoinValue)
+		assert.Equal(t, 58804.0, response.CoinValue)
+		assert.Equal(t, "mock", response.CoinValueProvider)
+		assert.Equal(t, "snapshot-1", response.CoinValueSnapshotId)
 		assert.Equal(t, "down", response.VoteDirection)
+		assert.Equal(t, con.VOTE_STATUS_LOST, response.Status)
+	}
+}
+
+func TestGetUserLastVoteResultExpired(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	mockProvider := setupTestProvider(58804.0)
+	r.GET("/users/:id/votes/result", GetLastUserVoteResult)
+
+	voteDateTime, _ := time.Parse(time.RFC3339, "2024-01-01T19:30:50.52Z")
+	mockUser := &models.User{
+		Id:    "1",
+		Score: 3,
+		Votes: []models.Vote{
+			{VoteDirection: "down", CoinValueAtVote: 45234, CoinValueCurrency: con.COIN_CURRENCY_USD, VoteCoin: con.COIN_TYPE_BTC, VoteDateTime: models.TimestampTime{Time: voteDateTime}},
+		},
 	}
+	mockDB.On("GetUserByID", "1").Return(mockUser, nil)
+	var updatedUser models.User
+	mockDB.On("UpdateUserVote", mock.AnythingOfType("models.User"), 0, "", 3.0).Run(func(args mock.Arguments) {
+		updatedUser = args.Get(0).(models.User)
+	}).Return(mockUser, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/1/votes/result", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.Vote
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, err)
+	// Checked long after its window, the vote expires without a price or a score change
+	assert.Equal(t, con.VOTE_STATUS_EXPIRED, response.Status)
+	assert.Equal(t, 0.0, response.CoinValue)
+	assert.Equal(t, con.VOTE_STATUS_EXPIRED, updatedUser.Votes[0].Status)
+	assert.Equal(t, 3.0, updatedUser.Score)
+	mockProvider.AssertNotCalled(t, "GetCurrentExchangeRate", mock.Anything)
+}
+
+func TestGetUserVoteById(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	r.GET("/users/:id/votes/result", GetLastUserVoteResult)
+	r.GET("/users/:id/votes/:voteId", GetUserVote)
+
+	// Stored to the second, as times read back from storage are
+	voteDateTime := time.Now().Add(-10 * time.Second).Truncate(time.Second)
+	mockUser := &models.User{Id: "1", Votes: []models.Vote{
+		// Cancelled and replaced in the same second as the vote after it, which only its id tells apart
+		{Id: "vote-1", VoteDirection: "up", VoteCoin: con.COIN_TYPE_BTC, Status: con.VOTE_STATUS_VOID, VoteDateTime: models.TimestampTime{Time: voteDateTime}},
+		{Id: "vote-2", VoteDirection: "down", VoteCoin: con.COIN_TYPE_BTC, Status: con.VOTE_STATUS_PENDING, VoteDateTime: models.TimestampTime{Time: voteDateTime}},
+	}}
+	mockDB.On("GetUserByID", "1").Return(mockUser, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/1/votes/vote-2", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.Vote
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "vote-2", response.Id)
+	assert.Equal(t, "down", response.VoteDirection)
+	assert.Equal(t, con.VOTE_STATUS_PENDING, response.Status)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/users/1/votes/vote-3", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), con.VOTE_NOT_FOUND)
+
+	// The latest vote is the one placed last, not the cancelled one
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/users/1/votes/result", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "vote-2", response.Id)
+	assert.Equal(t, con.VOTE_STATUS_PENDING, response.Status)
+}
+
+func TestGetUserVoteResultById(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	setupTestProvider(58804.0)
+	r.GET("/users/:id/votes/:voteId/result", GetUserVoteResult)
+
+	mockUser := &models.User{Id: "1", Votes: []models.Vote{
+		{Id: "vote-1", VoteDirection: "down", CoinValueAtVote: 60000, CoinValueCurrency: con.COIN_CURRENCY_USD, VoteCoin: con.COIN_TYPE_BTC, Status: con.VOTE_STATUS_PENDING, VoteDateTime: models.TimestampTime{Time: time.Now().Add(-time.Minute - 5*time.Second)}},
+	}}
+	mockDB.On("GetUserByID", "1").Return(mockUser, nil)
+	mockDB.On("UpdateUserVote", mock.AnythingOfType("models.User"), 0, con.VOTE_STATUS_PENDING, 0.0).Return(mockUser, nil)
+	var snapshot models.PriceSnapshot
+	mockDB.On("CreatePriceSnapshot", mock.AnythingOfType("models.PriceSnapshot")).Run(func(args mock.Arguments) {
+		snapshot = args.Get(0).(models.PriceSnapshot)
+	}).Return(&models.PriceSnapshot{Id: "snapshot-1"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/1/votes/vote-1/result", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.Vote
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "vote-1", response.Id)
+	assert.Equal(t, con.VOTE_STATUS_WON, response.Status)
+	assert.Equal(t, "vote-1", snapshot.VoteId)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/users/1/votes/vote-2/result", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), con.VOTE_NOT_FOUND)
+}
+
+func TestCreateUserVoteForCoin(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	setupTestProvider(2500.0)
+	r.POST("/users/:id/votes", CreateUserVote)
+
+	mockUser := &models.User{Id: "1", Name: "Test User", Email: "test@test.com"}
+	mockDB.On("GetUserByID", "1").Return(mockUser, nil)
+	var snapshot models.PriceSnapshot
+	mockDB.On("CreatePriceSnapshot", mock.AnythingOfType("models.PriceSnapshot")).Run(func(args mock.Arguments) {
+		snapshot = args.Get(0).(models.PriceSnapshot)
+	}).Return(&models.PriceSnapshot{Id: "snapshot-1"}, nil)
+	var updatedUser models.User
+	mockDB.On("PlaceUserVote", mock.AnythingOfType("models.User"), 0.0).Run(func(args mock.Arguments) {
+		updatedUser = args.Get(0).(models.User)
+	}).Return(mockUser, nil)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"vote_direction": "up", "vote_coin": "ETH"})
+	req, _ := http.NewRequest("POST", "/users/1/votes", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	assert.Len(t, updatedUser.Votes, 1)
+	assert.Equal(t, con.COIN_TYPE_ETH, updatedUser.Votes[0].VoteCoin)
+	assert.Equal(t, 2500.0, updatedUser.Votes[0].CoinValueAtVote)
+	assert.Equal(t, "snapshot-1", updatedUser.Votes[0].CoinValueAtVoteSnapshotId)
+	assert.Equal(t, con.VOTE_ROUND_DEFAULT, updatedUser.Votes[0].VoteRound)
+	assert.NotEmpty(t, updatedUser.Votes[0].Id)
+	assert.Equal(t, updatedUser.Votes[0].Id, snapshot.VoteId)
+	assert.Equal(t, 1.0, updatedUser.Votes[0].ScoreMultiplier)
+	assert.Equal(t, con.COIN_TYPE_ETH, snapshot.Coin)
+	assert.Equal(t, 2500.0, snapshot.Price)
+	assert.Equal(t, "mock", snapshot.Provider)
+}
+
+func TestCreateUserVoteIgnoresServerFields(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	setupTestProvider(2500.0)
+	r.POST("/users/:id/votes", CreateUserVote)
+
+	mockUser := &models.User{Id: "1", Name: "Test User", Email: "test@test.com"}
+	mockDB.On("GetUserByID", "1").Return(mockUser, nil)
+	mockDB.On("CreatePriceSnapshot", mock.AnythingOfType("models.PriceSnapshot")).Return(&models.PriceSnapshot{Id: "snapshot-1"}, nil)
+	var updatedUser models.User
+	mockDB.On("PlaceUserVote", mock.AnythingOfType("models.User"), 0.0).Run(func(args mock.Arguments) {
+		updatedUser = args.Get(0).(models.User)
+	}).Return(mockUser, nil)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{
+		"vote_direction":         "up",
+		"status":                 con.VOTE_STATUS_WON,
+		"coin_value":             3000,
+		"coin_value_provider":    "forged",
+		"coin_value_consensus":   gin.H{"spread": 1},
+		"coin_value_snapshot_id": "forged",
+		"cancelled_date_time":    "2019-10-12T07:20:52Z",
+		"resolved_date_time":     "2019-10-12T07:21:51Z",
+		"score_multiplier":       100,
+	})
+	req, _ := http.NewRequest("POST", "/users/1/votes", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	placed := updatedUser.Votes[0]
+	assert.Equal(t, con.VOTE_STATUS_PENDING, placed.Status)
+	assert.Zero(t, placed.CoinValue)
+	assert.Empty(t, placed.CoinValueProvider)
+	assert.Nil(t, placed.CoinValueConsensus)
+	assert.Empty(t, placed.CoinValueSnapshotId)
+	assert.Nil(t, placed.CancelledDateTime)
+	assert.Nil(t, placed.ResolvedDateTime)
+	assert.Equal(t, 1.0, placed.ScoreMultiplier)
+}
+
+func TestCreateUserVoteUnsupportedCoin(t *testing.T) {
+	r, _ := setupTestRouter()
+	r.POST("/users/:id/votes", CreateUserVote)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"vote_direction": "up", "vote_coin": "notacoin"})
+	req, _ := http.NewRequest("POST", "/users/1/votes", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestCreateUserVoteUnsupportedDirection(t *testing.T) {
+	r, _ := setupTestRouter()
+	r.POST("/users/:id/votes", CreateUserVote)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"vote_direction": "sideways"})
+	req, _ := http.NewRequest("POST", "/users/1/votes", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), con.VOTE_DIRECTION_NOT_SUPPORTED)
+}
+
+func TestCreateUserVoteStakeTooHigh(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	r.POST("/users/:id/votes", CreateUserVote)
+
+	mockDB.On("GetUserByID", "1").Return(&models.User{Id: "1", Score: 4}, nil)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"vote_direction": "up", "stake": 5})
+	req, _ := http.NewRequest("POST", "/users/1/votes", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), con.VOTE_STAKE_TOO_HIGH)
+	mockDB.AssertNotCalled(t, "PlaceUserVote", mock.Anything, mock.Anything)
+}
+
+func TestCreateUserVoteUnsupportedRound(t *testing.T) {
+	r, _ := setupTestRouter()
+	r.POST("/users/:id/votes", CreateUserVote)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"vote_direction": "up", "vote_round": "2m"})
+	req, _ := http.NewRequest("POST", "/users/1/votes", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), con.VOTE_ROUND_NOT_SUPPORTED)
+}
+
+func TestCreateUserVoteDuringLongerRound(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	r.POST("/users/:id/votes", CreateUserVote)
+
+	// A five minute vote placed two minutes ago is still running
+	mockUser := &models.User{Id: "1", Votes: []models.Vote{{
+		VoteDirection: "up",
+		VoteCoin:      con.COIN_TYPE_BTC,
+		VoteRound:     con.VOTE_ROUND_5M,
+		Status:        con.VOTE_STATUS_PENDING,
+		VoteDateTime:  models.TimestampTime{Time: time.Now().Add(-2 * time.Minute)},
+	}}}
+	mockDB.On("GetUserByID", "1").Return(mockUser, nil)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(gin.H{"vote_direction": "up", "vote_round": "1h"})
+	req, _ := http.NewRequest("POST", "/users/1/votes", bytes.NewBuffer(body))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestCancelUserVote(t *testing.T) {
+	r, mockDB := setupTestRouter()
+	setupTestProvider(2500.0)
+	r.DELETE("/users/:id/votes/:voteId", CancelUserVote)
+
+	mockUser := &models.User{Id: "1", Votes: []models.Vote{
+		{Id: "vote-1", VoteDirection: "up", VoteCoin: con.COIN_TYPE_BTC, CoinValueAtVote: 2500, CoinValueCurrency: con.COIN_CURRENCY_USD, Status: con.VOTE_STATUS_PENDING, VoteDateTime: models.TimestampTime{Time: time.Now()}},
+	}}
+	mockDB.On("GetUserByID", "1").Return(mockUser, nil)
+	mockDB.On("GetPriceSnapshotsBetween", con.COIN_TYPE_BTC, mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).Return([]models.PriceSnapshot{
+		{Id: "snapshot-1", Coin: con.COIN_TYPE_BTC, Currency: con.COIN_CURRENCY_USD, Price: 2500},
+	}, nil)
+	var updatedUser models.User
+	mockDB.On("UpdateUserVote", mock.AnythingOfType("models.User"), 0, con.VOTE_STATUS_PENDING, 0.0).Run(func(args mock.Arguments) {
+		updatedUser = args.Get(0).(models.User)
+	}).Return(mockUser, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1/votes/vote-1", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, con.VOTE_STATUS_VOID, updatedUser.Votes[0].Status)
+	assert.Equal(t, "snapshot-1", updatedUser.Votes[0].CoinValueSnapshotId)
+
+	// Once void, the vote can not be cancelled again
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/users/1/votes/vote-1", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), con.VOTE_CANCEL_TOO_LATE)
 }
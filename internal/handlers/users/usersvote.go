@@ -1,195 +1,241 @@
 package users
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 
 	"hermes-crypto-core/internal/coin"
 	con "hermes-crypto-core/internal/constants"
 	"hermes-crypto-core/internal/db"
 	"hermes-crypto-core/internal/models"
+	"hermes-crypto-core/internal/votes"
 )
 
 // GetUserVotes handles GET requests to retrieve the specified (by id) user's votes
 func GetUserVotesById(c *gin.Context) {
 	log.Default().Println("Getting user votes")
 	id := c.Param("id")
-	user, err := db.DB.GetUserByID(id)
+	user, err := db.DB.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": con.USER_NOT_FOUND})
 		return
 	}
 
+	// Votes whose window has passed show as resolvable until they are resolved
+	votes.AssignIds(user)
+	now := time.Now()
+	for i := range user.Votes {
+		user.Votes[i].Status = votes.CurrentStatus(user.Votes[i], now)
+	}
+
 	c.JSON(http.StatusOK, user.Votes)
 }
 
 // GetLastUserVoteResult handles GET requests to retrieve the specified (by id) user's last vote result
-// If there is a result, we update this here as well with the users score
+// If the vote's window has passed and the resolver has not got to it yet, we resolve it here, updating
+// the users score as well
 func GetLastUserVoteResult(c *gin.Context) {
 	id := c.Param("id")
-	user, err := db.DB.GetUserByID(id)
+
+	log.Default().Println("Getting last user vote result")
+
+	latestVote, err := votes.ResolveLatestVote(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": con.USER_NOT_FOUND, "message": err.Error()})
+		respondVoteError(c, err)
 		return
 	}
 
-	log.Default().Println("Getting last user vote result")
+	log.Default().Println("Latest vote=", latestVote)
+	// A user without votes has no result, so null is returned
+	c.JSON(http.StatusOK, latestVote)
+}
 
-	latestVote := GetLatestVote(*user)
-	// Check if there is a latest vote for us to check results against
+// GetUserVote handles GET requests to retrieve a vote (by voteId) of the specified (by id) user
+func GetUserVote(c *gin.Context) {
+	id := c.Param("id")
+	user, err := db.DB.GetUserByID(c.Request.Context(), id)
+	if err != nil || user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": con.USER_NOT_FOUND})
+		return
+	}
 
-	log.Default().Println("Latest vote=", latestVote)
-	if latestVote != nil {
-		isYoungerThanTimeout := time.Since(latestVote.VoteDateTime.Time) < 60*time.Second
-		log.Default().Println("Is recent=", isYoungerThanTimeout)
-		if isYoungerThanTimeout {
-			c.JSON(http.StatusOK, latestVote)
-			return
-		}
+	votes.AssignIds(user)
+	vote := votes.FindVote(*user, c.Param("voteId"))
+	if vote == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": con.VOTE_NOT_FOUND})
+		return
+	}
+	vote.Status = votes.CurrentStatus(*vote, time.Now())
 
-		// We have a coin value - it is all fine
-		if latestVote.CoinValue != 0 {
-			c.JSON(http.StatusOK, latestVote)
-			return
-		}
+	c.JSON(http.StatusOK, vote)
+}
 
-		// If the vote is not recent, we need to check the exchange rate and update the vote
-		currentExchangeRate, err := coin.GetCurrentExchangeRate()
-		if err != nil {
-			c.JSON(http.StatusFailedDependency, gin.H{"error": "Could not determine current exchange rate", "message": err.Error()})
-			return
-		}
-		latestVote.CoinValue = *currentExchangeRate
+// GetUserVoteResult handles GET requests to retrieve the result of a vote (by voteId) of the specified
+// (by id) user, resolving it first if its window has passed and it has not been resolved yet
+func GetUserVoteResult(c *gin.Context) {
+	id := c.Param("id")
+	voteId := c.Param("voteId")
 
-		log.Printf("Current exchange=$%f", latestVote.CoinValue)
+	log.Default().Println("Getting user vote result", voteId)
 
-		// Find and update the vote in the user's votes array
-		for i, vote := range user.Votes {
-			if vote.VoteDateTime == latestVote.VoteDateTime {
-				user.Votes[i] = *latestVote
-				break
-			}
-		}
+	vote, err := votes.ResolveVote(c.Request.Context(), id, voteId)
+	if err != nil {
+		respondVoteError(c, err)
+		return
+	}
 
-		// Update the user score
-		currentUserScore := user.Score
-		// If the value at vote is higher than the current value, then the value dropped
-		didCoinValueDrop := latestVote.CoinValueAtVote > latestVote.CoinValue
-		// Now determine how the user score should be updated given their last vote direction
-		if didCoinValueDrop {
-			// Thus if the user voted down, they get a point
-			if latestVote.VoteDirection == "down" {
-				currentUserScore += 1
-			} else {
-				currentUserScore -= 1
-			}
-		} else {
-			if latestVote.VoteDirection == "up" {
-				currentUserScore += 1
-			} else {
-				currentUserScore -= 1
-			}
-		}
-		user.Score = currentUserScore
+	c.JSON(http.StatusOK, vote)
+}
 
-		// Update the user with the new vote
-		updatedUser, err := db.DB.UpdateUser(id, *user, true)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": con.USER_VOTE_UPDATE_FAILED, "message": err.Error()})
-			return
-		}
-		log.Printf("Updated user vote for %v", updatedUser)
+// CancelUserVote handles DELETE requests to cancel a vote (by voteId) of the specified (by id) user.
+// Only votes placed moments ago, before a recorded price has moved, can be cancelled. The vote is kept as void.
+func CancelUserVote(c *gin.Context) {
+	id := c.Param("id")
+	voteId := c.Param("voteId")
 
-		// Return the updated vote
-		c.JSON(http.StatusOK, latestVote)
+	log.Default().Println("Cancelling user vote", voteId)
+
+	vote, err := votes.CancelVote(c.Request.Context(), id, voteId)
+	if err != nil {
+		respondVoteError(c, err)
 		return
 	}
 
-	// Otherwise there is nothing to return
-	c.JSON(http.StatusOK, nil)
+	c.JSON(http.StatusOK, vote)
+}
+
+// respondVoteError responds with the status matching the reason a vote could not be placed, resolved or cancelled
+func respondVoteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, votes.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": con.USER_NOT_FOUND, "message": err.Error()})
+	case errors.Is(err, votes.ErrVoteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": con.VOTE_NOT_FOUND})
+	case errors.Is(err, votes.ErrVoteInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": con.VOTE_IN_PROGRESS})
+	case errors.Is(err, votes.ErrVoteNotResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": con.VOTE_NOT_RESOLVED})
+	case errors.Is(err, votes.ErrScoreChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": con.VOTE_SCORE_CHANGED})
+	case errors.Is(err, votes.ErrStakeTooLow):
+		c.JSON(http.StatusBadRequest, gin.H{"error": con.VOTE_STAKE_TOO_LOW})
+	case errors.Is(err, votes.ErrStakeTooHigh):
+		c.JSON(http.StatusBadRequest, gin.H{"error": con.VOTE_STAKE_TOO_HIGH})
+	case errors.Is(err, votes.ErrCancelTooLate):
+		c.JSON(http.StatusConflict, gin.H{"error": con.VOTE_CANCEL_TOO_LATE})
+	case errors.Is(err, votes.ErrPriceMoved):
+		c.JSON(http.StatusConflict, gin.H{"error": con.VOTE_CANCEL_PRICE_MOVED})
+	case errors.Is(err, votes.ErrCoinNotSupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": con.COIN_NOT_SUPPORTED})
+	case errors.Is(err, votes.ErrPriceUnavailable):
+		c.JSON(http.StatusFailedDependency, gin.H{"error": votes.ErrPriceUnavailable.Error(), "message": err.Error()})
+	case errors.Is(err, votes.ErrSnapshotFailed):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": con.PRICE_SNAPSHOT_FAILED, "message": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": con.USER_VOTE_UPDATE_FAILED, "message": err.Error()})
+	}
 }
 
 // CreateUserVote handles POST requests to create a new user vote. We also run validation to see
 // if there is an ongoing vote already before going ahead and creating a new vote.
 func CreateUserVote(c *gin.Context) {
 	id := c.Param("id")
-	var newVote models.Vote
-	if err := c.ShouldBindJSON(&newVote); err != nil {
+	var request models.VoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Only the fields of the request are taken from the client
+	newVote := models.Vote{
+		VoteDirection:     request.VoteDirection,
+		VoteCoin:          request.VoteCoin,
+		CoinValueCurrency: request.CoinValueCurrency,
+		VoteRound:         request.VoteRound,
+		Stake:             request.Stake,
+	}
+
+	direction, ok := votes.GetSupportedDirection(newVote.VoteDirection)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": con.VOTE_DIRECTION_NOT_SUPPORTED})
+		return
+	}
+	newVote.VoteDirection = direction
+
+	// Votes without a coin default to Bitcoin, otherwise the coin has to be in our catalog
+	if newVote.VoteCoin == "" {
+		newVote.VoteCoin = con.COIN_TYPE_BTC
+	}
+	voteCoin, ok := coin.GetSupportedCoin(newVote.VoteCoin)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": con.COIN_NOT_SUPPORTED})
+		return
+	}
+	// The quote currency is optional, without it the vote is placed in the provider's quote currency
+	if newVote.CoinValueCurrency != "" {
+		currency, ok := coin.GetSupportedCurrency(newVote.CoinValueCurrency)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": con.CURRENCY_NOT_SUPPORTED})
+			return
+		}
+		newVote.CoinValueCurrency = currency
+	}
+	// Votes without a round run for a minute
+	round, ok := votes.GetSupportedRound(newVote.VoteRound)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": con.VOTE_ROUND_NOT_SUPPORTED})
+		return
+	}
+	newVote.VoteRound = round
 
 	// Check if user exists
-	user, err := db.DB.GetUserByID(id)
+	user, err := db.DB.GetUserByID(c.Request.Context(), id)
 	// If user does not exist, return an error since we can't add a vote to a non-existent user
 	if err != nil || user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": con.USER_NOT_FOUND, "message": err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": con.USER_NOT_FOUND})
 		return
 	}
 
-	// If user already exists, check if there is an ongoing vote
-	// Get the latest vote
-	latestVote := GetLatestVote(*user)
-	// If there is a vote, and it is recent, return an error noting there is an ongoing vote already
-	if latestVote != nil {
-		isRecent := time.Since(latestVote.VoteDateTime.Time) < 60*time.Second
-		// if there is an unresolved vote, return an error
-		if isRecent {
-			c.JSON(http.StatusConflict, gin.H{"error": "User already has an ongoing vote"})
-			return
-		}
-		// if there is an unchecked vote, return an error
-		if latestVote.CoinValue == 0 {
-			c.JSON(http.StatusConflict, gin.H{"error": "Users last vote has not been resolved"})
-			return
-		}
+	// Check if there is an ongoing vote, and that the stake is valid, before fetching the price. Both
+	// are checked again when the vote is stored.
+	if err := votes.CanPlace(*user, newVote.Stake); err != nil {
+		respondVoteError(c, err)
+		return
 	}
 
-	currentExchangeRate, err := coin.GetCurrentExchangeRate()
+	// Ids are given when the vote is placed, so the price recorded for it can refer to it
+	newVote.Id = uuid.New().String()
+	currentExchangeRate, err := coin.GetExchangeRateWithin(c.Request.Context(), *voteCoin, newVote.CoinValueCurrency, coin.VotePriceMaxAge)
 	if err != nil {
 		c.JSON(http.StatusFailedDependency, gin.H{"error": "Could not determine current exchange rate"})
 		return
 	}
+	snapshot, err := votes.RecordPriceSnapshot(c.Request.Context(), currentExchangeRate, newVote.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": con.PRICE_SNAPSHOT_FAILED, "message": err.Error()})
+		return
+	}
 	// Set the current exchange rate as the value of the coin at the time of the vote
-	newVote.CoinValueAtVote = *currentExchangeRate
+	newVote.CoinValueAtVote = currentExchangeRate.CoinValue
+	newVote.CoinValueAtVoteProvider = currentExchangeRate.Provider
+	newVote.CoinValueAtVoteConsensus = currentExchangeRate.Consensus
+	newVote.CoinValueAtVoteSnapshotId = snapshot.Id
 	// Add default values for the vote
 	newVote.VoteDateTime = models.TimestampTime{Time: time.Now()}
-	newVote.CoinValue = 0
-	newVote.VoteCoin = con.COIN_TYPE_BTC
-	newVote.CoinValueCurrency = con.COIN_CURRENCY_USD
+	newVote.Status = con.VOTE_STATUS_PENDING
+	newVote.ScoreMultiplier = votes.RoundMultipliers[round]
+	newVote.VoteCoin = voteCoin.Id
+	newVote.CoinValueCurrency = currentExchangeRate.CoinValueCurrency
 
-	// If there is no ongoing vote, create a new vote
-	user.Votes = append(user.Votes, newVote)
-
-	// Update the user with the extra votes
-	updatedUser, err := db.DB.UpdateUser(id, *user, false)
+	// If there is no ongoing vote, create a new vote, reserving its stake from the user's score
+	updatedUser, err := votes.PlaceVote(c.Request.Context(), id, newVote)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": con.USER_VOTE_UPDATE_FAILED, "message": err.Error()})
+		respondVoteError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, updatedUser.Votes)
 }
-
-func GetLatestVote(user models.User) *models.Vote {
-	if len(user.Votes) == 0 {
-		return nil
-	}
-
-	newestVote := &user.Votes[0]
-	newestTime := newestVote.VoteDateTime.Time
-
-	for i := 1; i < len(user.Votes); i++ {
-		currentVote := &user.Votes[i]
-		currentTime := currentVote.VoteDateTime.Time
-
-		if currentTime.After(newestTime) {
-			newestVote = currentVote
-			newestTime = currentTime
-		}
-	}
-
-	return newestVote
-}
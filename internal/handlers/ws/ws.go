@@ -0,0 +1,328 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"hermes-crypto-core/internal/auth"
+	"hermes-crypto-core/internal/coin"
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/events"
+	"hermes-crypto-core/internal/models"
+	"hermes-crypto-core/internal/votes"
+)
+
+// PingInterval is how often the server pings a client; clients that do not answer within two
+// intervals are disconnected
+var PingInterval = 30 * time.Second
+
+const (
+	writeTimeout = 10 * time.Second
+	// maxRequestSize bounds the size of a message sent by a client
+	maxRequestSize = 1024
+	// maxSubscriptions bounds the number of channels a single client may subscribe to
+	maxSubscriptions = 16
+	// sendBuffer is the number of messages a client may fall behind before it is disconnected
+	sendBuffer = 64
+)
+
+var upgrader = websocket.Upgrader{
+	// Mirrors the CORS middleware, which allows every origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// client is a single WebSocket connection and the channels it is subscribed to
+type client struct {
+	conn   *websocket.Conn
+	userId string
+	ctx    context.Context
+	cancel context.CancelFunc
+	send   chan models.SocketMessage
+
+	mu            sync.Mutex
+	subscriptions map[string]context.CancelFunc
+}
+
+// Connect handles GET requests to open the live updates WebSocket. Clients authenticate with the
+// token from the X-Auth-Token header of the sign in response, passed as the token query parameter
+// (browsers can not set headers on WebSockets) or as a bearer token. Once connected, clients send
+// subscribe and unsubscribe requests for the votes channel, carrying their own vote events, and
+// for prices:<coin> channels with an optional :<currency>, all multiplexed over the one connection.
+func Connect(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	userId, err := auth.VerifyToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": con.AUTH_TOKEN_INVALID, "message": err.Error()})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already responded to the client
+		log.Printf("Could not open WebSocket for user %s: %v", userId, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cl := &client{
+		conn:          conn,
+		userId:        userId,
+		ctx:           ctx,
+		cancel:        cancel,
+		send:          make(chan models.SocketMessage, sendBuffer),
+		subscriptions: map[string]context.CancelFunc{},
+	}
+	log.Printf("WebSocket opened for user %s", userId)
+
+	go cl.writeLoop()
+	cl.readLoop()
+
+	cancel()
+	log.Printf("WebSocket closed for user %s", userId)
+}
+
+// readLoop handles requests from the client until the connection is closed
+func (cl *client) readLoop() {
+	defer cl.conn.Close()
+
+	cl.conn.SetReadLimit(maxRequestSize)
+	cl.conn.SetReadDeadline(time.Now().Add(2 * PingInterval))
+	cl.conn.SetPongHandler(func(string) error {
+		return cl.conn.SetReadDeadline(time.Now().Add(2 * PingInterval))
+	})
+
+	for {
+		_, data, err := cl.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request models.SocketRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_ERROR, Message: err.Error()})
+			continue
+		}
+
+		switch request.Action {
+		case con.SOCKET_ACTION_SUBSCRIBE:
+			cl.subscribe(request.Channel)
+		case con.SOCKET_ACTION_UNSUBSCRIBE:
+			cl.unsubscribe(request.Channel)
+		default:
+			cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_ERROR, Channel: request.Channel, Message: con.SOCKET_ACTION_NOT_SUPPORTED})
+		}
+	}
+}
+
+// writeLoop is the only writer of the connection; it sends queued messages and pings the client
+func (cl *client) writeLoop() {
+	ping := time.NewTicker(PingInterval)
+	defer ping.Stop()
+	defer cl.conn.Close()
+
+	for {
+		select {
+		case <-cl.ctx.Done():
+			cl.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+			return
+		case message := <-cl.send:
+			cl.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := cl.conn.WriteJSON(message); err != nil {
+				cl.cancel()
+				return
+			}
+		case <-ping.C:
+			if err := cl.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				cl.cancel()
+				return
+			}
+		}
+	}
+}
+
+// push queues a message for the client. A client that can not keep up is disconnected, since
+// dropping single messages would leave it with a wrong picture of its votes.
+func (cl *client) push(message models.SocketMessage) {
+	select {
+	case cl.send <- message:
+	case <-cl.ctx.Done():
+	default:
+		log.Printf("WebSocket for user %s is not keeping up, disconnecting", cl.userId)
+		cl.cancel()
+	}
+}
+
+func (cl *client) subscribe(channel string) {
+	name, stream, errMessage := cl.resolveChannel(channel)
+	if stream == nil {
+		cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_ERROR, Channel: channel, Message: errMessage})
+		return
+	}
+
+	cl.mu.Lock()
+	if _, ok := cl.subscriptions[name]; ok {
+		cl.mu.Unlock()
+		cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_SUBSCRIBED, Channel: name})
+		return
+	}
+	if len(cl.subscriptions) >= maxSubscriptions {
+		cl.mu.Unlock()
+		cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_ERROR, Channel: channel, Message: con.SOCKET_TOO_MANY_SUBSCRIPTIONS})
+		return
+	}
+	ctx, cancel := context.WithCancel(cl.ctx)
+	cl.subscriptions[name] = cancel
+	cl.mu.Unlock()
+
+	// Streams acknowledge the subscription once they are listening, so no event is missed after it
+	go stream(ctx, name)
+}
+
+func (cl *client) unsubscribe(channel string) {
+	name, _, _ := cl.resolveChannel(channel)
+
+	cl.mu.Lock()
+	cancel, ok := cl.subscriptions[name]
+	delete(cl.subscriptions, name)
+	cl.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_UNSUBSCRIBED, Channel: name})
+}
+
+// resolveChannel normalises a channel name and returns the function streaming it, or an error
+// message if the channel is not supported
+func (cl *client) resolveChannel(channel string) (string, func(ctx context.Context, name string), string) {
+	parts := strings.Split(channel, ":")
+	switch {
+	case channel == con.SOCKET_CHANNEL_VOTES:
+		return channel, cl.streamVotes, ""
+	case parts[0] == con.SOCKET_CHANNEL_PRICES && (len(parts) == 2 || len(parts) == 3):
+		supportedCoin, ok := coin.GetSupportedCoin(parts[1])
+		if !ok {
+			return channel, nil, con.COIN_NOT_SUPPORTED
+		}
+		name := con.SOCKET_CHANNEL_PRICES + ":" + supportedCoin.Id
+		currency := ""
+		if len(parts) == 3 {
+			if currency, ok = coin.GetSupportedCurrency(parts[2]); !ok {
+				return channel, nil, con.CURRENCY_NOT_SUPPORTED
+			}
+			name += ":" + currency
+		}
+		return name, func(ctx context.Context, name string) {
+			cl.streamPrices(ctx, name, *supportedCoin, currency)
+		}, ""
+	default:
+		return channel, nil, con.SOCKET_CHANNEL_NOT_SUPPORTED
+	}
+}
+
+// streamPrices forwards the shared price feed of a coin until the subscription ends
+func (cl *client) streamPrices(ctx context.Context, name string, supportedCoin models.Coin, currency string) {
+	var lastId uint64
+	for subscribed := false; ctx.Err() == nil; subscribed = true {
+		backlog, ticks, unsubscribe := coin.SubscribePrices(supportedCoin, currency, lastId)
+		if !subscribed {
+			cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_SUBSCRIBED, Channel: name})
+		}
+		for _, tick := range backlog {
+			cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_PRICE, Channel: name, Id: tick.Id, Data: tick.Result})
+			lastId = tick.Id
+		}
+
+	forward:
+		for {
+			select {
+			case <-ctx.Done():
+				break forward
+			case tick, ok := <-ticks:
+				// Dropped by the feed for falling behind; resubscribe from the last tick sent
+				if !ok {
+					break forward
+				}
+				cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_PRICE, Channel: name, Id: tick.Id, Data: tick.Result})
+				lastId = tick.Id
+			}
+		}
+		unsubscribe()
+	}
+}
+
+// streamVotes forwards the vote events of the client's user until the subscription ends, and counts
+// down the vote in progress every second
+func (cl *client) streamVotes(ctx context.Context, name string) {
+	voteEvents, unsubscribe := events.Subscribe(cl.userId)
+	defer unsubscribe()
+	cl.push(models.SocketMessage{Type: con.SOCKET_MESSAGE_SUBSCRIBED, Channel: name})
+
+	// The vote in progress and its end, zero when there is nothing to count down
+	var countdownVoteId string
+	var countdownEnd time.Time
+	tick := func() {
+		if countdownEnd.IsZero() {
+			return
+		}
+		remaining := int(math.Ceil(time.Until(countdownEnd).Seconds()))
+		if remaining <= 0 {
+			remaining = 0
+			countdownEnd = time.Time{}
+		}
+		cl.push(models.SocketMessage{Type: con.VOTE_EVENT_COUNTDOWN, Channel: name, Data: models.VoteEvent{
+			Type:             con.VOTE_EVENT_COUNTDOWN,
+			UserId:           cl.userId,
+			VoteId:           countdownVoteId,
+			SecondsRemaining: &remaining,
+			Time:             models.TimestampTime{Time: time.Now().UTC()},
+		}})
+	}
+
+	// A vote placed before subscribing is counted down as well, as long as it is still running; a
+	// cancelled one is not
+	if user, err := db.DB.GetUserByID(ctx, cl.userId); err == nil && user != nil {
+		votes.AssignIds(user)
+		latestVote := votes.GetLatestVote(*user)
+		if latestVote != nil && votes.CurrentStatus(*latestVote, time.Now()) == con.VOTE_STATUS_PENDING {
+			countdownVoteId = latestVote.Id
+			countdownEnd = votes.WindowEnd(*latestVote)
+		}
+	}
+	tick()
+
+	countdown := time.NewTicker(time.Second)
+	defer countdown.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-voteEvents:
+			cl.push(models.SocketMessage{Type: event.Type, Channel: name, Data: event})
+			if event.Type == con.VOTE_EVENT_CREATED && event.Vote != nil {
+				countdownVoteId = event.VoteId
+				countdownEnd = votes.WindowEnd(*event.Vote)
+				tick()
+			}
+			// A cancelled vote is no longer counted down
+			if event.Type == con.VOTE_EVENT_CANCELLED && event.VoteId == countdownVoteId {
+				countdownEnd = time.Time{}
+			}
+		case <-countdown.C:
+			tick()
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"hermes-crypto-core/internal/auth"
+	"hermes-crypto-core/internal/coin"
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/events"
+	"hermes-crypto-core/internal/models"
+)
+
+// stubDB only knows the users it was given; any other call panics
+type stubDB struct {
+	db.DBInterface
+	users map[string]*models.User
+}
+
+func (s *stubDB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	if user, ok := s.users[id]; ok {
+		return user, nil
+	}
+	return nil, models.ReturnError{ErrorMessage: con.USER_NOT_FOUND}
+}
+
+type stubProvider struct{}
+
+func (stubProvider) Name() string {
+	return "stub"
+}
+
+func (stubProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USD
+}
+
+func (stubProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	price := 58804.0
+	return &price, nil
+}
+
+func setupServer(t *testing.T) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	db.DB = &stubDB{users: map[string]*models.User{"1": {Id: "1", Name: "John Doe"}}}
+	coin.Providers = []coin.PriceProvider{stubProvider{}}
+	coin.ResetCache()
+	coin.ResetBreakers()
+	coin.ResetQuotes()
+
+	r := gin.New()
+	r.GET("ws", Connect)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readMessage reads messages until one of the wanted type arrives
+func readMessage(t *testing.T, conn *websocket.Conn, messageType string) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		var message map[string]interface{}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("waiting for %s: %v", messageType, err)
+		}
+		if message["type"] == messageType {
+			return message
+		}
+	}
+}
+
+func TestConnectRejectsInvalidToken(t *testing.T) {
+	server := setupServer(t)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=not-a-token"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestConnectStreamsVoteEventsAndPrices(t *testing.T) {
+	server := setupServer(t)
+	conn := dial(t, server, auth.IssueToken("1"))
+
+	assert.NoError(t, conn.WriteJSON(models.SocketRequest{Action: con.SOCKET_ACTION_SUBSCRIBE, Channel: "votes"}))
+	assert.Equal(t, "votes", readMessage(t, conn, con.SOCKET_MESSAGE_SUBSCRIBED)["channel"])
+
+	assert.NoError(t, conn.WriteJSON(models.SocketRequest{Action: con.SOCKET_ACTION_SUBSCRIBE, Channel: "prices:btc"}))
+	assert.Equal(t, "prices:bitcoin", readMessage(t, conn, con.SOCKET_MESSAGE_SUBSCRIBED)["channel"])
+
+	price := readMessage(t, conn, con.SOCKET_MESSAGE_PRICE)
+	assert.Equal(t, "prices:bitcoin", price["channel"])
+	assert.Equal(t, 58804.0, price["data"].(map[string]interface{})["coin_value"])
+
+	// Vote events are only delivered to the user they belong to
+	events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_CREATED, UserId: "2", Vote: &models.Vote{VoteDirection: "down"}})
+	events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_CREATED, UserId: "1", Vote: &models.Vote{
+		VoteDirection: "up",
+		VoteDateTime:  models.TimestampTime{Time: time.Now()},
+	}})
+	created := readMessage(t, conn, con.VOTE_EVENT_CREATED)
+	assert.Equal(t, "up", created["data"].(map[string]interface{})["vote"].(map[string]interface{})["vote_direction"])
+
+	countdown := readMessage(t, conn, con.VOTE_EVENT_COUNTDOWN)
+	remaining := countdown["data"].(map[string]interface{})["seconds_remaining"].(float64)
+	assert.InDelta(t, time.Minute.Seconds(), remaining, 1)
+}
+
+func TestConnectOnlyCountsDownRunningVotes(t *testing.T) {
+	server := setupServer(t)
+	placed := models.TimestampTime{Time: time.Now().Add(-time.Second)}
+	db.DB.(*stubDB).users["1"].Votes = []models.Vote{{Id: "running", VoteDirection: "up", Status: con.VOTE_STATUS_PENDING, VoteDateTime: placed}}
+	db.DB.(*stubDB).users["2"] = &models.User{Id: "2", Votes: []models.Vote{{Id: "cancelled", VoteDirection: "up", Status: con.VOTE_STATUS_VOID, VoteDateTime: placed}}}
+
+	running := dial(t, server, auth.IssueToken("1"))
+	assert.NoError(t, running.WriteJSON(models.SocketRequest{Action: con.SOCKET_ACTION_SUBSCRIBE, Channel: "votes"}))
+	countdown := readMessage(t, running, con.VOTE_EVENT_COUNTDOWN)
+	assert.Equal(t, "running", countdown["data"].(map[string]interface{})["vote_id"])
+
+	// The cancelled vote is not counted down, so the first countdown is of the vote placed after it
+	cancelled := dial(t, server, auth.IssueToken("2"))
+	assert.NoError(t, cancelled.WriteJSON(models.SocketRequest{Action: con.SOCKET_ACTION_SUBSCRIBE, Channel: "votes"}))
+	readMessage(t, cancelled, con.SOCKET_MESSAGE_SUBSCRIBED)
+	events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_CREATED, UserId: "2", VoteId: "replacement", Vote: &models.Vote{
+		Id:            "replacement",
+		VoteDirection: "down",
+		VoteDateTime:  models.TimestampTime{Time: time.Now()},
+	}})
+	countdown = readMessage(t, cancelled, con.VOTE_EVENT_COUNTDOWN)
+	assert.Equal(t, "replacement", countdown["data"].(map[string]interface{})["vote_id"])
+}
+
+func TestConnectRejectsUnknownChannel(t *testing.T) {
+	server := setupServer(t)
+	conn := dial(t, server, auth.IssueToken("1"))
+
+	assert.NoError(t, conn.WriteJSON(models.SocketRequest{Action: con.SOCKET_ACTION_SUBSCRIBE, Channel: "prices:unknown"}))
+	assert.Equal(t, con.COIN_NOT_SUPPORTED, readMessage(t, conn, con.SOCKET_MESSAGE_ERROR)["message"])
+}
@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
+
+	con "hermes-crypto-core/internal/constants"
 )
 
 func RecoverMiddleware() gin.HandlerFunc {
@@ -29,6 +33,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Header("Access-Control-Allow-Credentials", "true")
 		c.Header("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Origin, Accept, Access-Control-Request-Method, Access-Control-Request-Headers, Referer, User-Agent, Sec-Fetch-Dest, Sec-Fetch-Mode, Sec-Fetch-Site, Sec-Ch-Ua, Sec-Ch-Ua-Mobile, Sec-Ch-Ua-Full-Version, Sec-Ch-Ua-Platform, Sec-Ch-Ua-Arch, Sec-Ch-Ua-Model")
 		c.Header("Access-Control-Allow-Methods", "POST, HEAD, PATCH, OPTIONS, GET, PUT")
+		c.Header("Access-Control-Expose-Headers", "X-Auth-Token")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -38,3 +43,21 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminMiddleware only lets through requests carrying the ADMIN_API_KEY in the X-Admin-Key header.
+// Without a configured key every request is refused, so admin endpoints are never left open.
+func AdminMiddleware() gin.HandlerFunc {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	if adminKey == "" {
+		log.Println("ADMIN_API_KEY is not set, admin endpoints are disabled")
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(con.ADMIN_KEY_HEADER)
+		if adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": con.ADMIN_KEY_INVALID})
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // HealthCheck is a struct that represents the health check response
 type HealthCheck struct {
 	Status string `json:"status" example:"ok"`
@@ -8,12 +10,36 @@ type HealthCheck struct {
 
 // Represents an individual vote
 type Vote struct {
-	VoteDirection     string        `json:"vote_direction" example:"up" enums:"up,down"`
-	VoteDateTime      TimestampTime `json:"vote_date_time" swaggertype:"primitive,string" example:"2019-10-12T07:20:50.52Z"`
-	VoteCoin          string        `json:"vote_coin" example:"bitcoin"`
-	CoinValue         float64       `json:"coin_value" example:"58950.000000"`
-	CoinValueAtVote   float64       `json:"coin_value_at_vote" example:"58940.000000"`
-	CoinValueCurrency string        `json:"coin_value_currency" example:"USD"`
+	Id                        string          `json:"id" example:"3f2b8c1a-9d4e-4f6a-b7c2-1e5d8a9f0b3c"`
+	VoteDirection             string          `json:"vote_direction" example:"up" enums:"up,down,flat"`
+	VoteDateTime              TimestampTime   `json:"vote_date_time" swaggertype:"primitive,string" example:"2019-10-12T07:20:50.52Z"`
+	VoteCoin                  string          `json:"vote_coin" example:"bitcoin"`
+	VoteRound                 string          `json:"vote_round" example:"1m" enums:"30s,1m,5m,1h,24h"`
+	ScoreMultiplier           float64         `json:"score_multiplier" example:"1"`     // Set from the round when the vote is placed
+	Stake                     float64         `json:"stake,omitempty" example:"10"`     // Points reserved from the score when the vote is placed
+	StakeOdds                 float64         `json:"stake_odds,omitempty" example:"2"` // Set from the direction when a staked vote is placed
+	Status                    string          `json:"status" example:"won" enums:"pending,resolvable,won,lost,tied,expired,void"`
+	CoinValue                 float64         `json:"coin_value" example:"58950.000000"`
+	CoinValueAtVote           float64         `json:"coin_value_at_vote" example:"58940.000000"`
+	CoinValueCurrency         string          `json:"coin_value_currency" example:"USDT" enums:"USD,USDT,EUR,GBP,ZAR"`
+	CoinValueProvider         string          `json:"coin_value_provider" example:"binance"`
+	CoinValueAtVoteProvider   string          `json:"coin_value_at_vote_provider" example:"binance"`
+	CoinValueConsensus        *PriceConsensus `json:"coin_value_consensus,omitempty"`
+	CoinValueAtVoteConsensus  *PriceConsensus `json:"coin_value_at_vote_consensus,omitempty"` // Only set when prices are determined by consensus
+	CoinValueSnapshotId       string          `json:"coin_value_snapshot_id,omitempty" example:"0b6c3d2e-7f1a-4e0e-9f57-6f1c3a2b9d10"`
+	CoinValueAtVoteSnapshotId string          `json:"coin_value_at_vote_snapshot_id,omitempty" example:"5d0f5a8e-2c4b-4b7a-8a47-1d6e2f3c4b5a"`
+	TargetDateTime            *TimestampTime  `json:"target_date_time,omitempty" swaggertype:"primitive,string" example:"2019-10-12T07:21:50Z"`    // When the vote's window ended, only set once resolved
+	CancelledDateTime         *TimestampTime  `json:"cancelled_date_time,omitempty" swaggertype:"primitive,string" example:"2019-10-12T07:20:52Z"` // Only set on void votes
+	ResolvedDateTime          *TimestampTime  `json:"resolved_date_time,omitempty" swaggertype:"primitive,string" example:"2019-10-12T07:21:51Z"`  // When the price the vote was resolved at was fetched, or when it expired
+}
+
+// VoteRequest is the body of a request to place a vote; everything else on the vote is set by the server
+type VoteRequest struct {
+	VoteDirection     string  `json:"vote_direction" example:"up" enums:"up,down,flat"`
+	VoteCoin          string  `json:"vote_coin" example:"bitcoin"`                                     // Bitcoin when not set
+	CoinValueCurrency string  `json:"coin_value_currency" example:"USDT" enums:"USD,USDT,EUR,GBP,ZAR"` // The provider's quote currency when not set
+	VoteRound         string  `json:"vote_round" example:"1m" enums:"30s,1m,5m,1h,24h"`                // A minute when not set
+	Stake             float64 `json:"stake,omitempty" example:"10"`
 }
 
 // User is a struct that represents a user with all of their votes
@@ -23,14 +49,153 @@ type User struct {
 	Email string  `json:"email" example:"test@test.com"` // Sort key
 	Score float64 `json:"score" example:"0"`
 	Votes []Vote  `json:"votes"`
+	// bcrypt hash of the user's password, never returned. Left out of updates that do not set it.
+	PasswordHash string `json:"-" dynamodbav:",omitempty"`
+}
+
+// UserRequest is the body of a request to sign up, or to sign in as the user with the same email
+type UserRequest struct {
+	Name     string `json:"name" example:"John Doe"`
+	Email    string `json:"email" example:"test@test.com"`
+	Password string `json:"password" example:"correct-horse-battery-staple"`
+}
+
+// Coin is a struct that represents a coin that can be predicted on
+type Coin struct {
+	Id              string            `json:"id" example:"bitcoin"`
+	Symbol          string            `json:"symbol" example:"BTC"`
+	Name            string            `json:"name" example:"Bitcoin"`
+	Decimals        int               `json:"decimals" example:"2"` // Number of decimals prices are displayed with
+	Icon            string            `json:"icon" example:"https://assets.coingecko.com/coins/images/1/large/bitcoin.png"`
+	ProviderSymbols map[string]string `json:"-"` // Symbol of the coin per price provider
 }
 
 // CoinResult is a struct that represents the result of a coin query
 type CoinResult struct {
-	Coin              string        `json:"vote_coin" example:"bitcoin"`
-	CoinValue         float64       `json:"coin_value" example:"58950.000000"`
-	CoinValueCurrency string        `json:"coin_value_currency" example:"USD"`
-	QueryTime         TimestampTime `json:"query_time" example:"2021-10-12T07:20:50.52Z"`
+	Coin              string          `json:"vote_coin" example:"bitcoin"`
+	CoinValue         float64         `json:"coin_value" example:"58950.000000"`
+	CoinValueCurrency string          `json:"coin_value_currency" example:"USDT" enums:"USD,USDT,EUR,GBP,ZAR"`
+	Provider          string          `json:"provider" example:"binance"`
+	QueryTime         TimestampTime   `json:"query_time" example:"2021-10-12T07:20:50.52Z"`
+	Consensus         *PriceConsensus `json:"consensus,omitempty"`
+}
+
+// Age returns how long ago the price was fetched from the provider(s)
+func (r CoinResult) Age() time.Duration {
+	return time.Since(r.QueryTime.Time)
+}
+
+// MarketSummary is a struct that represents the market statistics of a coin over the last 24 hours.
+// Statistics none of the providers reported are left out.
+type MarketSummary struct {
+	Coin                  string        `json:"vote_coin" example:"bitcoin"`
+	CoinValue             float64       `json:"coin_value" example:"58950.000000"`
+	CoinValueCurrency     string        `json:"coin_value_currency" example:"USDT" enums:"USD,USDT,EUR,GBP,ZAR"`
+	High24h               *float64      `json:"high_24h,omitempty" example:"59600.000000"`
+	Low24h                *float64      `json:"low_24h,omitempty" example:"57800.000000"`
+	Volume24h             *float64      `json:"volume_24h,omitempty" example:"1523000000.000000"` // In the quote currency
+	PriceChangePercent24h *float64      `json:"price_change_percent_24h,omitempty" example:"1.52"`
+	MarketCap             *float64      `json:"market_cap,omitempty" example:"1163000000000.000000"`
+	Providers             []string      `json:"providers" example:"binance,gecko"`
+	QueryTime             TimestampTime `json:"query_time" example:"2024-10-12T07:20:50Z"`
+}
+
+// ProviderUsage is a struct that represents the calls made to a price provider in a billing window,
+// measured against the provider's quota
+type ProviderUsage struct {
+	Provider     string `json:"provider" example:"gecko"` // Partition key
+	Window       string `json:"window" example:"2024-10"` // Sort key, the billing month in UTC
+	Calls        int    `json:"calls" example:"8120"`
+	Quota        int    `json:"quota" example:"10000"`
+	Reserve      int    `json:"reserve" example:"1000"` // Calls kept for when no other provider can answer
+	Remaining    int    `json:"remaining" example:"1880"`
+	FallbackOnly bool   `json:"fallback_only" example:"false"` // Only the reserve is left
+	Exhausted    bool   `json:"exhausted" example:"false"`
+}
+
+// ProviderHealth is a struct that represents the circuit breaker state of a price provider
+type ProviderHealth struct {
+	Provider            string         `json:"provider" example:"binance"`
+	State               string         `json:"state" example:"closed" enums:"closed,open,half-open"`
+	ConsecutiveFailures int            `json:"consecutive_failures" example:"0"`
+	TotalFailures       int            `json:"total_failures" example:"2"`
+	TotalSuccesses      int            `json:"total_successes" example:"120"`
+	LastError           string         `json:"last_error,omitempty" example:"Failed to retrieve data from Binance API"`
+	LastFailureAt       *TimestampTime `json:"last_failure_at,omitempty" example:"2024-10-12T07:20:50Z"`
+	OpenedAt            *TimestampTime `json:"opened_at,omitempty" example:"2024-10-12T07:20:50Z"`
+}
+
+// SourcePrice is the price a single provider reported during a consensus query
+type SourcePrice struct {
+	Provider string  `json:"provider" example:"binance"`
+	Price    float64 `json:"price" example:"58950.000000"`
+	Outlier  bool    `json:"outlier" example:"false"`
+}
+
+// PriceConsensus explains how a consensus price was reached across providers
+type PriceConsensus struct {
+	Sources []SourcePrice `json:"sources"`
+	Spread  float64       `json:"spread" example:"12.500000"` // Difference between the highest and lowest accepted price
+}
+
+// PriceSnapshot is a struct that represents a price as it was fetched from a provider, kept for auditing votes
+type PriceSnapshot struct {
+	Id        string          `json:"id" example:"0b6c3d2e-7f1a-4e0e-9f57-6f1c3a2b9d10"`                // Partition key
+	VoteId    string          `json:"vote_id,omitempty" example:"3f2b8c1a-9d4e-4f6a-b7c2-1e5d8a9f0b3c"` // The vote the price was fetched for
+	Coin      string          `json:"coin" example:"bitcoin"`
+	Currency  string          `json:"currency" example:"USDT"`
+	Provider  string          `json:"provider" example:"binance"`
+	Price     float64         `json:"price" example:"58950.000000"`
+	FetchedAt TimestampTime   `json:"fetched_at" example:"2024-10-12T07:20:50Z"`
+	Consensus *PriceConsensus `json:"consensus,omitempty"`
+}
+
+// Candle is a struct that represents the open, high, low and close prices of a coin over an interval
+type Candle struct {
+	OpenTime  TimestampTime `json:"open_time" example:"2024-10-12T07:20:00Z"`
+	CloseTime TimestampTime `json:"close_time" example:"2024-10-12T07:20:59Z"`
+	Open      float64       `json:"open" example:"58940.000000"`
+	High      float64       `json:"high" example:"58990.000000"`
+	Low       float64       `json:"low" example:"58920.000000"`
+	Close     float64       `json:"close" example:"58950.000000"`
+	Volume    float64       `json:"volume" example:"12.345000"` // Not every provider reports volume
+}
+
+// CoinHistory is a struct that represents a page of historical candles for a coin
+type CoinHistory struct {
+	Coin              string         `json:"vote_coin" example:"bitcoin"`
+	CoinValueCurrency string         `json:"coin_value_currency" example:"USDT"`
+	Interval          string         `json:"interval" example:"1m" enums:"1s,1m,5m,1h,1d"`
+	Provider          string         `json:"provider" example:"binance"`
+	Candles           []Candle       `json:"candles"`
+	NextFrom          *TimestampTime `json:"next_from,omitempty" example:"2024-10-12T15:40:00Z"` // Set when there are more candles to fetch
+}
+
+// VoteEvent is a struct that represents a change in the lifecycle of a user's vote
+type VoteEvent struct {
+	Type             string        `json:"type" example:"vote.resolved" enums:"vote.created,vote.cancelled,vote.countdown,vote.resolved,score.changed"`
+	UserId           string        `json:"user_id" example:"78712300234"`
+	VoteId           string        `json:"vote_id,omitempty" example:"3f2b8c1a-9d4e-4f6a-b7c2-1e5d8a9f0b3c"`
+	Vote             *Vote         `json:"vote,omitempty"`
+	SecondsRemaining *int          `json:"seconds_remaining,omitempty" example:"42"` // Only set on countdown events
+	PreviousScore    *float64      `json:"previous_score,omitempty" example:"3"`     // Only set on score changed events
+	Score            *float64      `json:"score,omitempty" example:"4"`
+	Time             TimestampTime `json:"time" example:"2024-10-12T07:20:50Z"`
+}
+
+// SocketRequest is a message sent by a client over the live updates WebSocket
+type SocketRequest struct {
+	Action  string `json:"action" example:"subscribe" enums:"subscribe,unsubscribe"`
+	Channel string `json:"channel" example:"prices:btc"` // votes, or prices:<coin> with an optional :<currency>
+}
+
+// SocketMessage is a message sent to clients over the live updates WebSocket
+type SocketMessage struct {
+	Type    string      `json:"type" example:"price" enums:"subscribed,unsubscribed,price,vote.created,vote.countdown,vote.resolved,score.changed,error"`
+	Channel string      `json:"channel,omitempty" example:"prices:bitcoin"`
+	Id      uint64      `json:"id,omitempty" example:"42"` // Tick id of price messages
+	Data    interface{} `json:"data,omitempty"`
+	Message string      `json:"message,omitempty" example:"Coin is not supported. Try another coin identifier."`
 }
 
 type ReturnError struct {
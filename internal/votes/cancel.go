@@ -0,0 +1,115 @@
+package votes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"hermes-crypto-core/internal/coin"
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/events"
+	"hermes-crypto-core/internal/models"
+)
+
+// CancelGrace is how long after it was placed a vote may still be cancelled
+var CancelGrace = 3 * time.Second
+
+// CancelToleranceBps is how far, in basis points of the price at vote, a price recorded since a vote
+// was placed may be from it for the vote to still be cancelled
+var CancelToleranceBps float64
+
+// Reasons a vote could not be cancelled, on top of those it could not be resolved for
+var (
+	ErrCancelTooLate = errors.New(con.VOTE_CANCEL_TOO_LATE)
+	ErrPriceMoved    = errors.New(con.VOTE_CANCEL_PRICE_MOVED)
+)
+
+// CancelVote voids the vote of a user with the given id, as long as it was placed within CancelGrace
+// and no price recorded since has moved beyond CancelToleranceBps. The vote stays in the user's votes
+// with the time it was cancelled and the latest price snapshot it was checked against, so cancellations
+// can be audited, and its stake is returned.
+func CancelVote(ctx context.Context, userId string, voteId string) (*models.Vote, error) {
+	unlock := lockUser(userId)
+	defer unlock()
+
+	user, err := db.DB.GetUserByID(ctx, userId)
+	if err == nil && user == nil {
+		err = errors.New(con.USER_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, &ResolveError{Reason: ErrUserNotFound, Err: err}
+	}
+
+	AssignIds(user)
+	vote := FindVote(*user, voteId)
+	if vote == nil {
+		return nil, &ResolveError{Reason: ErrVoteNotFound, Err: ErrVoteNotFound}
+	}
+	now := time.Now()
+	if CurrentStatus(*vote, now) != con.VOTE_STATUS_PENDING || now.After(cancelDeadline(*vote)) {
+		return nil, &ResolveError{Reason: ErrCancelTooLate, Err: ErrCancelTooLate}
+	}
+
+	voteCoin, ok := coin.GetSupportedCoin(vote.VoteCoin)
+	if !ok {
+		return nil, &ResolveError{Reason: ErrCoinNotSupported, Err: ErrCoinNotSupported}
+	}
+	snapshotId, err := checkPriceUnmoved(ctx, *vote, *voteCoin, now)
+	if err != nil {
+		return nil, err
+	}
+
+	previousStatus := vote.Status
+	if err := Transition(vote, con.VOTE_STATUS_VOID); err != nil {
+		return nil, &ResolveError{Reason: ErrCancelTooLate, Err: err}
+	}
+	vote.CoinValueSnapshotId = snapshotId
+	vote.CancelledDateTime = &models.TimestampTime{Time: now}
+
+	// The score is left as it is, apart from returning the stake
+	previousScore := user.Score
+	user.Score += vote.Stake
+	if err := store(ctx, user, vote, previousStatus, previousScore); err != nil {
+		return nil, err
+	}
+	log.Printf("Cancelled vote %s of user %s", vote.Id, user.Id)
+	events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_CANCELLED, UserId: user.Id, VoteId: vote.Id, Vote: vote})
+	publishScoreChanged(*user, *vote, previousScore)
+	return vote, nil
+}
+
+// cancelDeadline returns until when a vote may be cancelled. Votes are stored to the second, so it was
+// placed up to a second after its stored time; that second is added so the grace is never cut short.
+func cancelDeadline(vote models.Vote) time.Time {
+	return vote.VoteDateTime.Time.Truncate(time.Second).Add(time.Second + CancelGrace)
+}
+
+// checkPriceUnmoved returns ErrPriceMoved if a price snapshot of the vote's coin and currency fetched
+// since the vote was placed is beyond CancelToleranceBps of the price at vote. Only recorded prices are
+// checked, so cancelling does not fetch one. It returns the id of the latest snapshot checked, or of the
+// snapshot the vote was placed at if there is none.
+func checkPriceUnmoved(ctx context.Context, vote models.Vote, voteCoin models.Coin, now time.Time) (string, error) {
+	snapshots, err := db.DB.GetPriceSnapshotsBetween(ctx, voteCoin.Id, vote.VoteDateTime.Time, now)
+	if err != nil {
+		return "", &ResolveError{Reason: ErrPriceUnavailable, Err: err}
+	}
+
+	band := vote.CoinValueAtVote * CancelToleranceBps / 10000
+	snapshotId := vote.CoinValueAtVoteSnapshotId
+	var latest time.Time
+	for _, snapshot := range snapshots {
+		if snapshot.Currency != vote.CoinValueCurrency {
+			continue
+		}
+		if math.Abs(snapshot.Price-vote.CoinValueAtVote) > band {
+			return "", &ResolveError{Reason: ErrPriceMoved, Err: ErrPriceMoved}
+		}
+		if !snapshot.FetchedAt.Time.Before(latest) {
+			snapshotId, latest = snapshot.Id, snapshot.FetchedAt.Time
+		}
+	}
+	return snapshotId, nil
+}
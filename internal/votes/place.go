@@ -0,0 +1,76 @@
+package votes
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/events"
+	"hermes-crypto-core/internal/models"
+)
+
+// Reasons a vote could not be placed, on top of those it could not be resolved for. ErrScoreChanged is
+// also returned when a vote could not be resolved or cancelled because the user changed in the meantime.
+var (
+	ErrVoteInProgress  = errors.New(con.VOTE_IN_PROGRESS)
+	ErrVoteNotResolved = errors.New(con.VOTE_NOT_RESOLVED)
+	ErrStakeTooLow     = errors.New(con.VOTE_STAKE_TOO_LOW)
+	ErrStakeTooHigh    = errors.New(con.VOTE_STAKE_TOO_HIGH)
+	ErrScoreChanged    = errors.New(con.VOTE_SCORE_CHANGED)
+)
+
+// CanPlace returns an error if the user can not place a vote with the given stake: while their last
+// vote is running or waiting to be resolved, or if the stake is not valid for their score
+func CanPlace(user models.User, stake float64) error {
+	if latestVote := GetLatestVote(user); latestVote != nil {
+		switch CurrentStatus(*latestVote, time.Now()) {
+		case con.VOTE_STATUS_PENDING:
+			return &ResolveError{Reason: ErrVoteInProgress, Err: ErrVoteInProgress}
+		case con.VOTE_STATUS_RESOLVABLE:
+			return &ResolveError{Reason: ErrVoteNotResolved, Err: ErrVoteNotResolved}
+		}
+	}
+	return ValidateStake(stake, user.Score)
+}
+
+// PlaceVote adds a priced vote to a user's votes, reserving its stake from their score. The checks of
+// CanPlace are made again under the user's lock, and the vote is only stored if the score has not
+// changed since, so a stake can not be placed twice or take the score below zero.
+func PlaceVote(ctx context.Context, userId string, vote models.Vote) (*models.User, error) {
+	unlock := lockUser(userId)
+	defer unlock()
+
+	user, err := db.DB.GetUserByID(ctx, userId)
+	if err == nil && user == nil {
+		err = errors.New(con.USER_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, &ResolveError{Reason: ErrUserNotFound, Err: err}
+	}
+	if err := CanPlace(*user, vote.Stake); err != nil {
+		return nil, err
+	}
+
+	vote.StakeOdds = 0
+	if vote.Stake > 0 {
+		vote.StakeOdds = StakeOdds[vote.VoteDirection]
+	}
+	// Earlier votes without an id are stored with theirs
+	AssignIds(user)
+	user.Votes = append(user.Votes, vote)
+	previousScore := user.Score
+	user.Score -= vote.Stake
+
+	updatedUser, err := db.DB.PlaceUserVote(ctx, *user, previousScore)
+	if errors.Is(err, db.ErrScoreChanged) {
+		return nil, &ResolveError{Reason: ErrScoreChanged, Err: err}
+	}
+	if err != nil {
+		return nil, &ResolveError{Reason: ErrUpdateFailed, Err: err}
+	}
+	events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_CREATED, UserId: userId, VoteId: vote.Id, Vote: &vote})
+	publishScoreChanged(*user, vote, previousScore)
+	return updatedUser, nil
+}
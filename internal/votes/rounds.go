@@ -0,0 +1,75 @@
+package votes
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// Rounds maps each round a vote can be placed for to how long it runs
+var Rounds = map[string]time.Duration{
+	con.VOTE_ROUND_30S: 30 * time.Second,
+	con.VOTE_ROUND_1M:  time.Minute,
+	con.VOTE_ROUND_5M:  5 * time.Minute,
+	con.VOTE_ROUND_1H:  time.Hour,
+	con.VOTE_ROUND_24H: 24 * time.Hour,
+}
+
+// RoundMultipliers scale the points a vote wins or loses by its round, so longer rounds pay more
+var RoundMultipliers = map[string]float64{
+	con.VOTE_ROUND_30S: 1,
+	con.VOTE_ROUND_1M:  1,
+	con.VOTE_ROUND_5M:  1.5,
+	con.VOTE_ROUND_1H:  2,
+	con.VOTE_ROUND_24H: 3,
+}
+
+// configureRounds reads VOTE_MULTIPLIER_<ROUND> (e.g. VOTE_MULTIPLIER_5M) for each round
+func configureRounds() {
+	for round := range Rounds {
+		value, err := strconv.ParseFloat(os.Getenv("VOTE_MULTIPLIER_"+strings.ToUpper(round)), 64)
+		if err == nil && value > 0 {
+			RoundMultipliers[round] = value
+		}
+	}
+}
+
+// GetSupportedRound returns the round with the given name, or false if votes can not be placed for it.
+// An empty name is the default round.
+func GetSupportedRound(round string) (string, bool) {
+	if round == "" {
+		return con.VOTE_ROUND_DEFAULT, true
+	}
+	round = strings.ToLower(round)
+	_, ok := Rounds[round]
+	return round, ok
+}
+
+// Duration returns how long a vote runs. Votes placed before rounds run for the default round.
+func Duration(vote models.Vote) time.Duration {
+	if duration, ok := Rounds[vote.VoteRound]; ok {
+		return duration
+	}
+	return Rounds[con.VOTE_ROUND_DEFAULT]
+}
+
+// WindowEnd returns when a vote's window ends and it can be resolved
+func WindowEnd(vote models.Vote) time.Time {
+	return vote.VoteDateTime.Time.Add(Duration(vote))
+}
+
+// Multiplier returns the multiplier a vote is scored with: the one of its round when it was placed,
+// so later configuration changes do not affect votes already running
+func Multiplier(vote models.Vote) float64 {
+	if vote.ScoreMultiplier > 0 {
+		return vote.ScoreMultiplier
+	}
+	if multiplier, ok := RoundMultipliers[vote.VoteRound]; ok {
+		return multiplier
+	}
+	return 1
+}
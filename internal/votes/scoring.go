@@ -0,0 +1,73 @@
+package votes
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// FlatTolerance is how far, in the vote's quote currency, the price may move during a vote's window
+// for the market to count as flat. FlatToleranceBps is the same in basis points of the price at vote;
+// when both are set the wider band is used.
+var (
+	FlatTolerance    float64
+	FlatToleranceBps float64
+)
+
+// configureFlatTolerance reads VOTE_FLAT_TOLERANCE and VOTE_FLAT_TOLERANCE_BPS
+func configureFlatTolerance() {
+	if value, err := strconv.ParseFloat(os.Getenv("VOTE_FLAT_TOLERANCE"), 64); err == nil && value >= 0 {
+		FlatTolerance = value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv("VOTE_FLAT_TOLERANCE_BPS"), 64); err == nil && value >= 0 {
+		FlatToleranceBps = value
+	}
+}
+
+// GetSupportedDirection returns the direction with the given name, or false if it can not be predicted
+func GetSupportedDirection(direction string) (string, bool) {
+	direction = strings.ToLower(direction)
+	switch direction {
+	case con.VOTE_DIRECTION_UP, con.VOTE_DIRECTION_DOWN, con.VOTE_DIRECTION_FLAT:
+		return direction, true
+	}
+	return direction, false
+}
+
+// Movement returns the direction the price of a resolved vote moved in: flat if it stayed within the
+// flat tolerance of the price at vote, and up or down otherwise
+func Movement(vote models.Vote) string {
+	change := vote.CoinValue - vote.CoinValueAtVote
+	band := math.Max(FlatTolerance, vote.CoinValueAtVote*FlatToleranceBps/10000)
+	switch {
+	case math.Abs(change) <= band:
+		return con.VOTE_DIRECTION_FLAT
+	case change > 0:
+		return con.VOTE_DIRECTION_UP
+	default:
+		return con.VOTE_DIRECTION_DOWN
+	}
+}
+
+// ScoreChange returns how a resolved vote changes the user's score: a point for predicting the
+// movement of the price and minus one otherwise, scaled by the multiplier of the vote's round.
+// Staked votes win their stake at the vote's odds, scaled the same way, and lose their stake
+// otherwise. A flat market that was not predicted leaves the score as it is.
+func ScoreChange(vote models.Vote) float64 {
+	win, loss := Multiplier(vote), Multiplier(vote)
+	if vote.Stake > 0 {
+		win, loss = vote.Stake*(Odds(vote)-1)*Multiplier(vote), vote.Stake
+	}
+	switch movement := Movement(vote); {
+	case vote.VoteDirection == movement:
+		return win
+	case movement == con.VOTE_DIRECTION_FLAT:
+		return 0
+	default:
+		return -loss
+	}
+}
@@ -0,0 +1,74 @@
+package votes
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// MinStake is the fewest points that can be staked on a vote, and MaxStakeRatio the largest share of
+// the user's score that can be staked on one
+var (
+	MinStake      = 1.0
+	MaxStakeRatio = 0.5
+)
+
+// StakeOdds are the decimal odds a won stake is paid out at for each direction: a stake of 10 at odds
+// of 2 pays out 20, winning 10 points. Flat markets are rarer, so predicting one pays more.
+var StakeOdds = map[string]float64{
+	con.VOTE_DIRECTION_UP:   2,
+	con.VOTE_DIRECTION_DOWN: 2,
+	con.VOTE_DIRECTION_FLAT: 5,
+}
+
+// configureStakes reads VOTE_MIN_STAKE, VOTE_MAX_STAKE_RATIO and VOTE_STAKE_ODDS_<DIRECTION>
+// (e.g. VOTE_STAKE_ODDS_FLAT)
+func configureStakes() {
+	if value, err := strconv.ParseFloat(os.Getenv("VOTE_MIN_STAKE"), 64); err == nil && value > 0 {
+		MinStake = value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv("VOTE_MAX_STAKE_RATIO"), 64); err == nil && value > 0 && value <= 1 {
+		MaxStakeRatio = value
+	}
+	for direction := range StakeOdds {
+		value, err := strconv.ParseFloat(os.Getenv("VOTE_STAKE_ODDS_"+strings.ToUpper(direction)), 64)
+		if err == nil && value > 1 {
+			StakeOdds[direction] = value
+		}
+	}
+}
+
+// ValidateStake returns ErrStakeTooLow if the stake is below MinStake, and ErrStakeTooHigh if it is
+// more than MaxStakeRatio of the score, which also keeps the score from going below zero. Votes
+// without a stake are always valid.
+func ValidateStake(stake float64, score float64) error {
+	switch {
+	case stake == 0:
+		return nil
+	case stake < MinStake:
+		return &ResolveError{Reason: ErrStakeTooLow, Err: ErrStakeTooLow}
+	case stake > score*MaxStakeRatio:
+		return &ResolveError{Reason: ErrStakeTooHigh, Err: ErrStakeTooHigh}
+	}
+	return nil
+}
+
+// Odds returns the odds a vote's stake is paid out at: the ones of its direction when it was placed
+func Odds(vote models.Vote) float64 {
+	if vote.StakeOdds > 0 {
+		return vote.StakeOdds
+	}
+	if odds, ok := StakeOdds[vote.VoteDirection]; ok {
+		return odds
+	}
+	return 2
+}
+
+// Payout returns the points a resolved vote pays out: its score change, plus the stake that was
+// reserved when it was placed
+func Payout(vote models.Vote) float64 {
+	return ScoreChange(vote) + vote.Stake
+}
@@ -0,0 +1,66 @@
+package votes
+
+import (
+	"fmt"
+	"time"
+
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/models"
+)
+
+// ResolveTolerance is how long after its window a vote may still be resolved. Votes checked later
+// expire without changing the score, since the price has moved on from the end of their window.
+var ResolveTolerance = 2 * time.Minute
+
+// transitions lists the statuses a vote may move to from each status; the outcomes are final
+var transitions = map[string][]string{
+	con.VOTE_STATUS_PENDING:    {con.VOTE_STATUS_RESOLVABLE, con.VOTE_STATUS_VOID},
+	con.VOTE_STATUS_RESOLVABLE: {con.VOTE_STATUS_WON, con.VOTE_STATUS_LOST, con.VOTE_STATUS_TIED, con.VOTE_STATUS_EXPIRED},
+}
+
+// Transition moves a vote to the given status, or returns an error if the vote can not get there
+// from its current status
+func Transition(vote *models.Vote, status string) error {
+	for _, allowed := range transitions[vote.Status] {
+		if allowed == status {
+			vote.Status = status
+			return nil
+		}
+	}
+	return fmt.Errorf("vote can not go from %s to %s", vote.Status, status)
+}
+
+// CurrentStatus returns the status of a vote at the given time. A pending vote whose window has
+// passed is resolvable, even though that is only stored once the vote is resolved. Votes placed
+// before statuses were stored get theirs from whether they were priced.
+func CurrentStatus(vote models.Vote, now time.Time) string {
+	status := vote.Status
+	if status == "" {
+		status = con.VOTE_STATUS_PENDING
+		if vote.CoinValue != 0 {
+			status = outcome(vote)
+		}
+	}
+	if status == con.VOTE_STATUS_PENDING && !now.Before(WindowEnd(vote)) {
+		status = con.VOTE_STATUS_RESOLVABLE
+	}
+	return status
+}
+
+// IsExpired reports whether a vote was checked too long after its window to be resolved
+func IsExpired(vote models.Vote, now time.Time) bool {
+	return now.After(WindowEnd(vote).Add(ResolveTolerance))
+}
+
+// outcome returns the status of a priced vote: won or lost, or tied when it neither gains nor
+// loses points
+func outcome(vote models.Vote) string {
+	switch change := ScoreChange(vote); {
+	case change > 0:
+		return con.VOTE_STATUS_WON
+	case change < 0:
+		return con.VOTE_STATUS_LOST
+	default:
+		return con.VOTE_STATUS_TIED
+	}
+}
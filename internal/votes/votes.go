@@ -0,0 +1,427 @@
+package votes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"hermes-crypto-core/internal/coin"
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/events"
+	"hermes-crypto-core/internal/models"
+)
+
+// ResolveInterval is how often the resolver looks for votes whose window has passed
+var ResolveInterval = 10 * time.Second
+
+// ResolvePriceWindow is how far from the end of a vote's window the price it is resolved at may
+// have been fetched, for rounds long enough that this is at most a tenth of the round; shorter rounds
+// allow a tenth of the round (see PriceWindow). Votes resolved later use a stored snapshot or the
+// price history instead.
+var ResolvePriceWindow = 15 * time.Second
+
+// Reasons a vote could not be resolved, matched with errors.Is
+var (
+	ErrUserNotFound     = errors.New(con.USER_NOT_FOUND)
+	ErrVoteNotFound     = errors.New(con.VOTE_NOT_FOUND)
+	ErrCoinNotSupported = errors.New(con.COIN_NOT_SUPPORTED)
+	ErrPriceUnavailable = errors.New("Could not determine current exchange rate")
+	ErrSnapshotFailed   = errors.New(con.PRICE_SNAPSHOT_FAILED)
+	ErrUpdateFailed     = errors.New(con.USER_VOTE_UPDATE_FAILED)
+)
+
+// ResolveError is returned when a vote could not be placed, resolved or cancelled; Reason is one of the
+// errors above and Err the error that caused it
+type ResolveError struct {
+	Reason error
+	Err    error
+}
+
+func (e *ResolveError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResolveError) Is(target error) bool {
+	return e.Reason == target
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// userLocks serialise the changes to a user's votes within this process, so the resolver and a client
+// checking its result do not price the same vote twice. Across processes, writes are conditional on the
+// stored votes and score instead (see store). Users share a lock by hash to keep their number bounded.
+var userLocks [64]sync.Mutex
+
+func lockUser(id string) func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	lock := &userLocks[hash.Sum32()%uint32(len(userLocks))]
+	lock.Lock()
+	return lock.Unlock
+}
+
+// Init reads VOTE_RESOLVE_INTERVAL, which sets how often the background resolver runs,
+// VOTE_RESOLVE_TOLERANCE, which sets how late a vote may be resolved before it expires, and
+// VOTE_PRICE_WINDOW, which sets how near the end of the window a vote's price must be, and
+// VOTE_CANCEL_GRACE and VOTE_CANCEL_TOLERANCE_BPS, which set how long and how far from its price a
+// vote may be cancelled, along with the score multipliers of the rounds, the band within which the
+// market counts as flat and the stakes
+func Init() {
+	configureRounds()
+	configureFlatTolerance()
+	configureStakes()
+	if interval, err := time.ParseDuration(os.Getenv("VOTE_RESOLVE_INTERVAL")); err == nil && interval > 0 {
+		ResolveInterval = interval
+	}
+	if tolerance, err := time.ParseDuration(os.Getenv("VOTE_RESOLVE_TOLERANCE")); err == nil && tolerance >= 0 {
+		ResolveTolerance = tolerance
+	}
+	if window, err := time.ParseDuration(os.Getenv("VOTE_PRICE_WINDOW")); err == nil && window > 0 {
+		ResolvePriceWindow = window
+	}
+	if grace, err := time.ParseDuration(os.Getenv("VOTE_CANCEL_GRACE")); err == nil && grace >= 0 {
+		CancelGrace = grace
+	}
+	if tolerance, err := strconv.ParseFloat(os.Getenv("VOTE_CANCEL_TOLERANCE_BPS"), 64); err == nil && tolerance >= 0 {
+		CancelToleranceBps = tolerance
+	}
+}
+
+// GetLatestVote returns the most recently placed vote of a user, or nil if the user has not voted.
+// Votes are stored to the second, so of votes placed in the same second the one placed last, which
+// comes last in the user's votes, is the latest; e.g. a vote placed right after cancelling another.
+func GetLatestVote(user models.User) *models.Vote {
+	if len(user.Votes) == 0 {
+		return nil
+	}
+
+	newestVote := &user.Votes[0]
+	newestTime := newestVote.VoteDateTime.Time
+
+	for i := 1; i < len(user.Votes); i++ {
+		currentVote := &user.Votes[i]
+		currentTime := currentVote.VoteDateTime.Time
+
+		if !currentTime.Before(newestTime) {
+			newestVote = currentVote
+			newestTime = currentTime
+		}
+	}
+
+	return newestVote
+}
+
+// voteIdNamespace derives the ids of votes placed before votes were given one
+var voteIdNamespace = uuid.MustParse("9d4c1f0e-63a7-4b1e-8f43-2a5b7c9e0d16")
+
+// AssignIds gives every vote of a user without an id one derived from the user and the vote's place
+// in their votes, so it is the same every time it is read until the user is stored with it. Votes are
+// only ever appended, never removed.
+func AssignIds(user *models.User) {
+	for i := range user.Votes {
+		if user.Votes[i].Id == "" {
+			user.Votes[i].Id = uuid.NewSHA1(voteIdNamespace, []byte(fmt.Sprintf("%s/%d", user.Id, i))).String()
+		}
+	}
+}
+
+// FindVote returns the vote of a user with the given id, or nil if the user has no such vote
+func FindVote(user models.User, voteId string) *models.Vote {
+	for i := range user.Votes {
+		if user.Votes[i].Id == voteId {
+			return &user.Votes[i]
+		}
+	}
+	return nil
+}
+
+// IsDue reports whether a vote's window has passed without the vote being resolved
+func IsDue(vote models.Vote, now time.Time) bool {
+	return CurrentStatus(vote, now) == con.VOTE_STATUS_RESOLVABLE
+}
+
+// ResolveLatestVote resolves the latest vote of a user if its window has passed, and returns it.
+// Votes still in progress and votes already resolved are returned with their current status, and
+// nil is returned if the user has not voted.
+func ResolveLatestVote(ctx context.Context, userId string) (*models.Vote, error) {
+	vote, _, err := resolveVote(ctx, userId, "")
+	return vote, err
+}
+
+// ResolveVote resolves the vote of a user with the given id if its window has passed, and returns it
+// with its current status
+func ResolveVote(ctx context.Context, userId string, voteId string) (*models.Vote, error) {
+	vote, _, err := resolveVote(ctx, userId, voteId)
+	if err == nil && vote == nil {
+		err = &ResolveError{Reason: ErrVoteNotFound, Err: ErrVoteNotFound}
+	}
+	return vote, err
+}
+
+// resolveVote resolves the vote of a user with the given id, or their latest vote if the id is empty,
+// also reporting whether it resolved the vote. A nil vote is returned if there is no such vote.
+func resolveVote(ctx context.Context, userId string, voteId string) (*models.Vote, bool, error) {
+	unlock := lockUser(userId)
+	defer unlock()
+
+	// Read under the lock, so a vote resolved while we waited is seen as resolved
+	user, err := db.DB.GetUserByID(ctx, userId)
+	if err == nil && user == nil {
+		err = errors.New(con.USER_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, false, &ResolveError{Reason: ErrUserNotFound, Err: err}
+	}
+
+	AssignIds(user)
+	vote := GetLatestVote(*user)
+	if voteId != "" {
+		vote = FindVote(*user, voteId)
+	}
+	if vote == nil {
+		return nil, false, nil
+	}
+	previousStatus := vote.Status
+	vote.Status = CurrentStatus(*vote, time.Now())
+	if vote.Status != con.VOTE_STATUS_RESOLVABLE {
+		return vote, false, nil
+	}
+	if err := resolve(ctx, user, vote, previousStatus); err != nil {
+		return nil, false, err
+	}
+	return vote, true, nil
+}
+
+// resolve prices a resolvable vote at the end of its window, scores it and stores it along with the
+// user's new score, or expires it if it is resolved too late. The vote is a pointer into user.Votes,
+// and previousStatus the status it was stored with. Callers hold the user's lock.
+func resolve(ctx context.Context, user *models.User, vote *models.Vote, previousStatus string) error {
+	now := time.Now()
+	target := WindowEnd(*vote)
+	vote.TargetDateTime = &models.TimestampTime{Time: target}
+
+	if IsExpired(*vote, now) {
+		vote.ResolvedDateTime = &models.TimestampTime{Time: now}
+		if err := Transition(vote, con.VOTE_STATUS_EXPIRED); err != nil {
+			return &ResolveError{Reason: ErrUpdateFailed, Err: err}
+		}
+		// The score is left as it is, apart from returning the stake
+		previousScore := user.Score
+		user.Score += vote.Stake
+		if err := store(ctx, user, vote, previousStatus, previousScore); err != nil {
+			return err
+		}
+		log.Printf("Expired vote %s of user %s", vote.Id, user.Id)
+		events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_RESOLVED, UserId: user.Id, VoteId: vote.Id, Vote: vote})
+		publishScoreChanged(*user, *vote, previousScore)
+		return nil
+	}
+
+	voteCoin, ok := coin.GetSupportedCoin(vote.VoteCoin)
+	if !ok {
+		return &ResolveError{Reason: ErrCoinNotSupported, Err: ErrCoinNotSupported}
+	}
+	// Resolve in the same quote currency the vote was placed in, so the values are comparable
+	targetExchangeRate, snapshot, err := priceAt(ctx, vote.Id, *voteCoin, vote.CoinValueCurrency, target, PriceWindow(*vote))
+	if err != nil {
+		return err
+	}
+	vote.CoinValue = targetExchangeRate.CoinValue
+	vote.CoinValueProvider = targetExchangeRate.Provider
+	vote.CoinValueConsensus = targetExchangeRate.Consensus
+	vote.CoinValueSnapshotId = snapshot.Id
+	vote.ResolvedDateTime = &models.TimestampTime{Time: snapshot.FetchedAt.Time}
+
+	log.Printf("Exchange at %v=$%f (fetched at %v)", target, vote.CoinValue, snapshot.FetchedAt.Time)
+
+	if err := Transition(vote, outcome(*vote)); err != nil {
+		return &ResolveError{Reason: ErrUpdateFailed, Err: err}
+	}
+	previousScore := user.Score
+	user.Score += Payout(*vote)
+
+	// Update the user with the resolved vote
+	if err := store(ctx, user, vote, previousStatus, previousScore); err != nil {
+		return err
+	}
+	log.Printf("Resolved vote %s of user %s as %s", vote.Id, user.Id, vote.Status)
+	events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_RESOLVED, UserId: user.Id, VoteId: vote.Id, Vote: vote})
+	publishScoreChanged(*user, *vote, previousScore)
+	return nil
+}
+
+// store writes the votes and score of a user after one of their votes changed, as long as the stored
+// vote still has previousStatus and the stored score is still previousScore. Another process that
+// resolved the vote or placed a new one in the meantime makes it fail with ErrScoreChanged, rather
+// than have its write overwritten.
+func store(ctx context.Context, user *models.User, vote *models.Vote, previousStatus string, previousScore float64) error {
+	index := -1
+	for i := range user.Votes {
+		if user.Votes[i].Id == vote.Id {
+			index = i
+		}
+	}
+	_, err := db.DB.UpdateUserVote(ctx, *user, index, previousStatus, previousScore)
+	if errors.Is(err, db.ErrScoreChanged) {
+		return &ResolveError{Reason: ErrScoreChanged, Err: err}
+	}
+	if err != nil {
+		return &ResolveError{Reason: ErrUpdateFailed, Err: err}
+	}
+	return nil
+}
+
+// publishScoreChanged notifies the user if the vote changed their score from the previous score
+func publishScoreChanged(user models.User, vote models.Vote, previousScore float64) {
+	if user.Score != previousScore {
+		events.Publish(models.VoteEvent{Type: con.VOTE_EVENT_SCORE_CHANGED, UserId: user.Id, VoteId: vote.Id, PreviousScore: &previousScore, Score: &user.Score})
+	}
+}
+
+// PriceWindow returns how far from the end of its window the price a vote is resolved at may have been
+// fetched: ResolvePriceWindow, or a tenth of the round if that is shorter
+func PriceWindow(vote models.Vote) time.Duration {
+	return min(ResolvePriceWindow, Duration(vote)/10)
+}
+
+// priceAt returns the price of a coin at the end of a vote's window along with the snapshot recording
+// it. Shortly after the window a price is fetched now; later the nearest snapshot is used if one was
+// fetched close enough to the end of the window, and otherwise the provider's price history. How close
+// is given by window. Prices fetched here are recorded for the vote with the given id.
+func priceAt(ctx context.Context, voteId string, voteCoin models.Coin, currency string, target time.Time, window time.Duration) (*models.CoinResult, *models.PriceSnapshot, error) {
+	late := time.Since(target) > window
+	if late {
+		if snapshot := nearestSnapshot(ctx, voteCoin, currency, target, window); snapshot != nil {
+			return &models.CoinResult{
+				Coin:              snapshot.Coin,
+				CoinValue:         snapshot.Price,
+				CoinValueCurrency: snapshot.Currency,
+				Provider:          snapshot.Provider,
+				QueryTime:         snapshot.FetchedAt,
+				Consensus:         snapshot.Consensus,
+			}, snapshot, nil
+		}
+	}
+
+	var exchangeRate *models.CoinResult
+	var err error
+	if late {
+		exchangeRate, err = coin.GetPriceAt(ctx, voteCoin, currency, target, window)
+	} else {
+		exchangeRate, err = coin.GetExchangeRateWithin(ctx, voteCoin, currency, coin.VotePriceMaxAge)
+	}
+	if err != nil {
+		return nil, nil, &ResolveError{Reason: ErrPriceUnavailable, Err: err}
+	}
+	snapshot, err := RecordPriceSnapshot(ctx, exchangeRate, voteId)
+	if err != nil {
+		return nil, nil, &ResolveError{Reason: ErrSnapshotFailed, Err: err}
+	}
+	return exchangeRate, snapshot, nil
+}
+
+// nearestSnapshot returns the snapshot of a coin in the currency fetched nearest the target, if one
+// was fetched within the window of it
+func nearestSnapshot(ctx context.Context, voteCoin models.Coin, currency string, target time.Time, window time.Duration) *models.PriceSnapshot {
+	snapshots, err := db.DB.GetPriceSnapshotsBetween(ctx, voteCoin.Id, target.Add(-window), target.Add(window))
+	if err != nil {
+		log.Printf("Could not read price snapshots of %s: %v", voteCoin.Id, err)
+		return nil
+	}
+
+	var nearest *models.PriceSnapshot
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		if snapshot.Currency != currency || snapshot.Price <= 0 {
+			continue
+		}
+		if nearest == nil || snapshot.FetchedAt.Sub(target).Abs() < nearest.FetchedAt.Sub(target).Abs() {
+			nearest = snapshot
+		}
+	}
+	return nearest
+}
+
+// ResolveDueVotes resolves every vote whose window has passed and returns how many were resolved.
+// Users are read a page at a time, so every user is reached however large the table is. A vote that
+// can not be resolved is logged and retried on the next run, rather than holding up the votes of
+// other users.
+func ResolveDueVotes(ctx context.Context) (int, error) {
+	resolved := 0
+	now := time.Now()
+	err := db.DB.ScanUsers(ctx, func(users []models.User) error {
+		for _, user := range users {
+			AssignIds(&user)
+			latestVote := GetLatestVote(user)
+			if latestVote == nil || !IsDue(*latestVote, now) {
+				continue
+			}
+			_, ok, err := resolveVote(ctx, user.Id, latestVote.Id)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Another process may have resolved the vote, or the user placed a new one, since they were read
+			if err != nil && !errors.Is(err, ErrScoreChanged) {
+				log.Printf("Could not resolve vote %s of user %s: %v", latestVote.Id, user.Id, err)
+			}
+			if err != nil {
+				continue
+			}
+			// Another resolver may have got there first
+			if ok {
+				resolved++
+			}
+		}
+		return nil
+	})
+	return resolved, err
+}
+
+// StartResolver resolves due votes every ResolveInterval until the context is cancelled, so votes
+// are scored when their window passes rather than when the client checks the result
+func StartResolver(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ResolveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if resolved, err := ResolveDueVotes(ctx); err != nil {
+					log.Printf("Vote resolver failed: %v", err)
+				} else if resolved > 0 {
+					log.Printf("Vote resolver resolved %d votes", resolved)
+				}
+			}
+		}
+	}()
+	log.Printf("Vote resolver started, running every %s", ResolveInterval)
+}
+
+// RecordPriceSnapshot stores the price used for the vote with the given id, so the outcome of the vote
+// can be audited later
+func RecordPriceSnapshot(ctx context.Context, coinResult *models.CoinResult, voteId string) (*models.PriceSnapshot, error) {
+	return db.DB.CreatePriceSnapshot(ctx, models.PriceSnapshot{
+		Id:        uuid.New().String(),
+		VoteId:    voteId,
+		Coin:      coinResult.Coin,
+		Currency:  coinResult.CoinValueCurrency,
+		Provider:  coinResult.Provider,
+		Price:     coinResult.CoinValue,
+		FetchedAt: models.TimestampTime{Time: coinResult.QueryTime.UTC()},
+		Consensus: coinResult.Consensus,
+	})
+}
@@ -0,0 +1,609 @@
+package votes
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hermes-crypto-core/internal/coin"
+	con "hermes-crypto-core/internal/constants"
+	"hermes-crypto-core/internal/db"
+	"hermes-crypto-core/internal/models"
+)
+
+// stubDB keeps users in memory; any other call panics
+type stubDB struct {
+	db.DBInterface
+	mu        sync.Mutex
+	users     map[string]models.User
+	updates   int
+	snapshots []models.PriceSnapshot
+	pageSize  int
+	pages     int
+}
+
+// ScanUsers hands out the users pageSize at a time, or all at once when pageSize is zero
+func (s *stubDB) ScanUsers(ctx context.Context, handle func(users []models.User) error) error {
+	s.mu.Lock()
+	users := make([]models.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, s.copy(user))
+	}
+	s.mu.Unlock()
+
+	for len(users) > 0 {
+		size := len(users)
+		if s.pageSize > 0 && s.pageSize < size {
+			size = s.pageSize
+		}
+		s.pages++
+		if err := handle(users[:size]); err != nil {
+			return err
+		}
+		users = users[size:]
+	}
+	return nil
+}
+
+func (s *stubDB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, models.ReturnError{ErrorMessage: con.USER_NOT_FOUND}
+	}
+	user = s.copy(user)
+	return &user, nil
+}
+
+// UpdateUserVote stores the user if their score, number of votes and the status of the vote at index
+// are still the previous ones, like the real condition
+func (s *stubDB) UpdateUserVote(ctx context.Context, user models.User, index int, previousStatus string, previousScore float64) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.users[user.Id]
+	if stored.Score != previousScore || len(stored.Votes) != len(user.Votes) || stored.Votes[index].Status != previousStatus {
+		return nil, db.ErrScoreChanged
+	}
+	s.users[user.Id] = s.copy(user)
+	s.updates++
+	return &user, nil
+}
+
+// PlaceUserVote stores the user if their score is still the previous score and they have one vote
+// fewer, like the real condition
+func (s *stubDB) PlaceUserVote(ctx context.Context, user models.User, previousScore float64) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.users[user.Id]
+	if stored.Score != previousScore || len(stored.Votes) != len(user.Votes)-1 {
+		return nil, db.ErrScoreChanged
+	}
+	s.users[user.Id] = s.copy(user)
+	s.updates++
+	return &user, nil
+}
+
+func (s *stubDB) CreatePriceSnapshot(ctx context.Context, snapshot models.PriceSnapshot) (*models.PriceSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = append(s.snapshots, snapshot)
+	return &snapshot, nil
+}
+
+func (s *stubDB) GetPriceSnapshotsBetween(ctx context.Context, coin string, from time.Time, to time.Time) ([]models.PriceSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var snapshots []models.PriceSnapshot
+	for _, snapshot := range s.snapshots {
+		if snapshot.Coin == coin && !snapshot.FetchedAt.Before(from) && !snapshot.FetchedAt.After(to) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// copy returns a user whose votes do not share memory with the stored user. Callers hold mu.
+func (s *stubDB) copy(user models.User) models.User {
+	user.Votes = append([]models.Vote(nil), user.Votes...)
+	return user
+}
+
+type stubProvider struct {
+	price float64
+}
+
+func (p stubProvider) Name() string {
+	return "stub"
+}
+
+func (p stubProvider) QuoteCurrency() string {
+	return con.COIN_CURRENCY_USD
+}
+
+func (p stubProvider) GetCurrentExchangeRate(ctx context.Context, coin models.Coin) (*float64, error) {
+	return &p.price, nil
+}
+
+// GetHistory returns a candle of the requested interval opening at 10 below the current price and
+// closing at 10 above it
+func (p stubProvider) GetHistory(ctx context.Context, historyCoin models.Coin, interval string, from, to time.Time, limit int) ([]models.Candle, error) {
+	return []models.Candle{{
+		OpenTime:  models.TimestampTime{Time: from},
+		CloseTime: models.TimestampTime{Time: from.Add(coin.HistoryIntervals[interval] - time.Millisecond)},
+		Open:      p.price - 10,
+		Close:     p.price + 10,
+	}}, nil
+}
+
+func setup(price float64, users ...models.User) *stubDB {
+	stored := &stubDB{users: map[string]models.User{}}
+	for _, user := range users {
+		stored.users[user.Id] = user
+	}
+	db.DB = stored
+	coin.Providers = []coin.PriceProvider{stubProvider{price: price}}
+	coin.ResetCache()
+	coin.ResetBreakers()
+	coin.ResetQuotes()
+	return stored
+}
+
+func vote(direction string, placed time.Time) models.Vote {
+	return models.Vote{
+		VoteDirection:     direction,
+		VoteCoin:          con.COIN_TYPE_BTC,
+		CoinValueAtVote:   100,
+		CoinValueCurrency: con.COIN_CURRENCY_USD,
+		VoteDateTime:      models.TimestampTime{Time: placed},
+	}
+}
+
+func TestResolveDueVotes(t *testing.T) {
+	// The window has just ended, so the current price is used
+	due := time.Now().Add(-time.Minute - time.Second)
+	resolved := vote("down", due.Add(-time.Hour))
+	resolved.CoinValue = 90
+	stored := setup(110,
+		models.User{Id: "winner", Score: 3, Votes: []models.Vote{resolved, vote("up", due)}},
+		models.User{Id: "loser", Votes: []models.Vote{vote("down", due)}},
+		models.User{Id: "in-progress", Votes: []models.Vote{vote("up", time.Now())}},
+		models.User{Id: "resolved", Score: 1, Votes: []models.Vote{resolved}},
+		models.User{Id: "new"},
+	)
+	// The users table is read over several pages
+	stored.pageSize = 2
+
+	count, err := ResolveDueVotes(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stored.pages)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 2, stored.updates)
+	assert.Equal(t, 4.0, stored.users["winner"].Score)
+	assert.Equal(t, 110.0, stored.users["winner"].Votes[1].CoinValue)
+	assert.Equal(t, 90.0, stored.users["winner"].Votes[0].CoinValue)
+	assert.Equal(t, -1.0, stored.users["loser"].Score)
+	assert.Equal(t, 0.0, stored.users["in-progress"].Votes[0].CoinValue)
+	assert.Equal(t, 1.0, stored.users["resolved"].Score)
+	assert.Equal(t, con.VOTE_STATUS_WON, stored.users["winner"].Votes[1].Status)
+	assert.Equal(t, con.VOTE_STATUS_LOST, stored.users["loser"].Votes[0].Status)
+
+	// Resolved votes are left alone on the next run
+	count, err = ResolveDueVotes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Len(t, stored.snapshots, 2)
+}
+
+func TestResolveLatestVoteErrors(t *testing.T) {
+	unsupported := vote("up", time.Now().Add(-2*time.Minute))
+	unsupported.VoteCoin = "notacoin"
+	setup(110, models.User{Id: "1", Votes: []models.Vote{unsupported}})
+
+	_, err := ResolveLatestVote(context.Background(), "2")
+	assert.True(t, errors.Is(err, ErrUserNotFound))
+
+	_, err = ResolveLatestVote(context.Background(), "1")
+	assert.True(t, errors.Is(err, ErrCoinNotSupported))
+}
+
+func TestAssignIdsIsStable(t *testing.T) {
+	user := models.User{Id: "1", Votes: []models.Vote{vote("up", time.Now()), vote("down", time.Now())}}
+	user.Votes[1].Id = "placed-with-id"
+	again := models.User{Id: "1", Votes: []models.Vote{vote("up", time.Now())}}
+
+	AssignIds(&user)
+	AssignIds(&again)
+
+	assert.NotEmpty(t, user.Votes[0].Id)
+	assert.Equal(t, user.Votes[0].Id, again.Votes[0].Id)
+	assert.Equal(t, "placed-with-id", user.Votes[1].Id)
+	assert.Equal(t, &user.Votes[1], FindVote(user, "placed-with-id"))
+	assert.Nil(t, FindVote(user, "unknown"))
+}
+
+func TestResolveVoteById(t *testing.T) {
+	due := vote("up", time.Now().Add(-time.Minute-time.Second))
+	due.Id = "due"
+	stored := setup(110, models.User{Id: "1", Votes: []models.Vote{due}})
+
+	_, err := ResolveVote(context.Background(), "1", "unknown")
+	assert.True(t, errors.Is(err, ErrVoteNotFound))
+
+	resolved, err := ResolveVote(context.Background(), "1", "due")
+
+	assert.NoError(t, err)
+	assert.Equal(t, con.VOTE_STATUS_WON, resolved.Status)
+	assert.Equal(t, con.VOTE_STATUS_WON, stored.users["1"].Votes[0].Status)
+	assert.Equal(t, "due", stored.snapshots[0].VoteId)
+}
+
+func TestScoreChange(t *testing.T) {
+	up, down := vote("up", time.Time{}), vote("down", time.Time{})
+	up.CoinValue, down.CoinValue = 101, 99
+
+	assert.Equal(t, 1.0, ScoreChange(up))
+	assert.Equal(t, 1.0, ScoreChange(down))
+	up.CoinValue, down.CoinValue = 99, 101
+	assert.Equal(t, -1.0, ScoreChange(up))
+	assert.Equal(t, -1.0, ScoreChange(down))
+}
+
+func TestFlatMarket(t *testing.T) {
+	defer func() { FlatTolerance, FlatToleranceBps = 0, 0 }()
+	up, flat := vote("up", time.Time{}), vote("flat", time.Time{})
+	up.CoinValue, flat.CoinValue = 100, 100
+
+	// An unchanged price is neutral unless flat was predicted
+	assert.Equal(t, 0.0, ScoreChange(up))
+	assert.Equal(t, con.VOTE_STATUS_TIED, outcome(up))
+	assert.Equal(t, 1.0, ScoreChange(flat))
+
+	// Within the band the market is flat, outside it flat votes lose
+	FlatTolerance = 0.5
+	up.CoinValue, flat.CoinValue = 100.4, 100.4
+	assert.Equal(t, 0.0, ScoreChange(up))
+	assert.Equal(t, 1.0, ScoreChange(flat))
+	FlatTolerance, FlatToleranceBps = 0, 100
+	up.CoinValue, flat.CoinValue = 101.5, 101.5
+	assert.Equal(t, 1.0, ScoreChange(up))
+	assert.Equal(t, -1.0, ScoreChange(flat))
+
+	_, ok := GetSupportedDirection("sideways")
+	assert.False(t, ok)
+	direction, ok := GetSupportedDirection("Flat")
+	assert.True(t, ok)
+	assert.Equal(t, con.VOTE_DIRECTION_FLAT, direction)
+}
+
+func TestRoundsSetWindowAndMultiplier(t *testing.T) {
+	now := time.Now()
+	long := vote("up", now.Add(-2*time.Minute))
+	long.VoteRound = con.VOTE_ROUND_5M
+	long.Status = con.VOTE_STATUS_PENDING
+	assert.Equal(t, con.VOTE_STATUS_PENDING, CurrentStatus(long, now))
+	assert.Equal(t, con.VOTE_STATUS_RESOLVABLE, CurrentStatus(long, now.Add(3*time.Minute)))
+	assert.False(t, IsExpired(long, now.Add(4*time.Minute)))
+
+	// The multiplier stored when the vote was placed wins over the configured one
+	long.CoinValue = 90
+	assert.Equal(t, -1.5, ScoreChange(long))
+	long.ScoreMultiplier = 4
+	assert.Equal(t, -4.0, ScoreChange(long))
+
+	round, ok := GetSupportedRound("")
+	assert.True(t, ok)
+	assert.Equal(t, con.VOTE_ROUND_1M, round)
+	_, ok = GetSupportedRound("2m")
+	assert.False(t, ok)
+}
+
+func TestTransitionsAreEnforced(t *testing.T) {
+	v := vote("up", time.Now())
+	v.Status = con.VOTE_STATUS_PENDING
+
+	assert.Error(t, Transition(&v, con.VOTE_STATUS_WON))
+	assert.NoError(t, Transition(&v, con.VOTE_STATUS_RESOLVABLE))
+	assert.NoError(t, Transition(&v, con.VOTE_STATUS_WON))
+	// Outcomes are final
+	assert.Error(t, Transition(&v, con.VOTE_STATUS_LOST))
+	assert.Equal(t, con.VOTE_STATUS_WON, v.Status)
+}
+
+func TestCurrentStatus(t *testing.T) {
+	now := time.Now()
+	pending := vote("up", now)
+	pending.Status = con.VOTE_STATUS_PENDING
+	assert.Equal(t, con.VOTE_STATUS_PENDING, CurrentStatus(pending, now))
+	assert.Equal(t, con.VOTE_STATUS_RESOLVABLE, CurrentStatus(pending, now.Add(time.Minute)))
+
+	// Votes stored before statuses get theirs from their price
+	legacy := vote("down", now.Add(-time.Hour))
+	assert.Equal(t, con.VOTE_STATUS_RESOLVABLE, CurrentStatus(legacy, now))
+	legacy.CoinValue = 90
+	assert.Equal(t, con.VOTE_STATUS_WON, CurrentStatus(legacy, now))
+}
+
+func TestResolveDueVotesExpiresLateVotes(t *testing.T) {
+	stored := setup(110, models.User{Id: "1", Score: 2, Votes: []models.Vote{vote("up", time.Now().Add(-time.Hour))}})
+
+	count, err := ResolveDueVotes(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, con.VOTE_STATUS_EXPIRED, stored.users["1"].Votes[0].Status)
+	assert.Equal(t, 2.0, stored.users["1"].Score)
+	assert.Empty(t, stored.snapshots)
+}
+
+func TestResolveLateVoteAtEndOfWindow(t *testing.T) {
+	// Placed so that its window ended at 20 seconds past the minute, a minute ago
+	target := time.Now().Add(-time.Minute).Truncate(time.Minute).Add(20 * time.Second)
+	placed := target.Add(-time.Minute)
+	stored := setup(110, models.User{Id: "1", Votes: []models.Vote{vote("up", placed)}})
+
+	// Without a snapshot, the price comes from the one second candle opening at the end of the window
+	resolved, err := ResolveLatestVote(context.Background(), "1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, resolved.CoinValue)
+	// An unchanged price is flat, so the up vote ties
+	assert.Equal(t, con.VOTE_STATUS_TIED, resolved.Status)
+	assert.Equal(t, target, resolved.TargetDateTime.Time)
+	assert.True(t, target.Equal(resolved.ResolvedDateTime.Time))
+	assert.Len(t, stored.snapshots, 1)
+
+	// A snapshot fetched near the end of the window is preferred, and not stored again
+	stored.users["2"] = models.User{Id: "2", Votes: []models.Vote{vote("down", placed)}}
+	stored.snapshots = []models.PriceSnapshot{{
+		Id:        "near",
+		Coin:      con.COIN_TYPE_BTC,
+		Currency:  con.COIN_CURRENCY_USD,
+		Price:     95,
+		FetchedAt: models.TimestampTime{Time: target.Add(2 * time.Second)},
+	}}
+
+	resolved, err = ResolveLatestVote(context.Background(), "2")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 95.0, resolved.CoinValue)
+	assert.Equal(t, "near", resolved.CoinValueSnapshotId)
+	assert.Equal(t, con.VOTE_STATUS_WON, resolved.Status)
+	assert.True(t, target.Add(2*time.Second).Equal(resolved.ResolvedDateTime.Time))
+	assert.Len(t, stored.snapshots, 1)
+
+	// The window is scaled down to the round, so a snapshot fetched 8 seconds after the end of a one
+	// minute round is too far from it
+	stored.users["3"] = models.User{Id: "3", Votes: []models.Vote{vote("down", placed)}}
+	stored.snapshots[0].FetchedAt = models.TimestampTime{Time: target.Add(8 * time.Second)}
+
+	resolved, err = ResolveLatestVote(context.Background(), "3")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, resolved.CoinValue)
+	assert.True(t, target.Equal(resolved.ResolvedDateTime.Time))
+}
+
+// snapshotAt returns a price snapshot of bitcoin in dollars fetched at the given time
+func snapshotAt(id string, price float64, fetched time.Time) models.PriceSnapshot {
+	return models.PriceSnapshot{
+		Id:        id,
+		Coin:      con.COIN_TYPE_BTC,
+		Currency:  con.COIN_CURRENCY_USD,
+		Price:     price,
+		FetchedAt: models.TimestampTime{Time: fetched},
+	}
+}
+
+func TestLatestVotePlacedInTheSameSecond(t *testing.T) {
+	// Stored times are truncated to the second, so a vote placed right after cancelling another has
+	// the same time
+	placed := time.Now().Add(-2 * time.Second).Truncate(time.Second)
+	cancelled := vote("up", placed)
+	cancelled.Id, cancelled.Status = "cancelled", con.VOTE_STATUS_VOID
+	running := vote("down", placed)
+	running.Id, running.Status, running.Stake = "running", con.VOTE_STATUS_PENDING, 1
+	user := models.User{Id: "1", Score: 4, Votes: []models.Vote{cancelled, running}}
+
+	assert.Equal(t, "running", GetLatestVote(user).Id)
+	// The running vote keeps another from being placed
+	assert.True(t, errors.Is(CanPlace(user, 0), ErrVoteInProgress))
+
+	// Once its window has passed, the latest vote is the one resolved
+	user.Votes[0].VoteDateTime.Time = placed.Add(-time.Minute)
+	user.Votes[1].VoteDateTime.Time = placed.Add(-time.Minute)
+	setup(110, user)
+
+	resolved, err := ResolveLatestVote(context.Background(), "1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "running", resolved.Id)
+	assert.Equal(t, con.VOTE_STATUS_LOST, resolved.Status)
+}
+
+func TestCancelVote(t *testing.T) {
+	placed := vote("up", time.Now().Add(-time.Second))
+	placed.Id, placed.Status, placed.Stake, placed.CoinValueAtVoteSnapshotId = "placed", con.VOTE_STATUS_PENDING, 3, "at-vote"
+	// The live price has moved, but only recorded prices are checked
+	stored := setup(105, models.User{Id: "1", Score: 2, Votes: []models.Vote{placed}})
+	stored.snapshots = []models.PriceSnapshot{
+		snapshotAt("before", 90, placed.VoteDateTime.Add(-time.Second)),
+		snapshotAt("unmoved", 100, placed.VoteDateTime.Add(500*time.Millisecond)),
+	}
+
+	cancelled, err := CancelVote(context.Background(), "1", "placed")
+
+	assert.NoError(t, err)
+	assert.Equal(t, con.VOTE_STATUS_VOID, cancelled.Status)
+	assert.NotNil(t, cancelled.CancelledDateTime)
+	// The vote is kept with the latest price it was checked against, and its stake is returned
+	assert.Len(t, stored.users["1"].Votes, 1)
+	assert.Equal(t, con.VOTE_STATUS_VOID, stored.users["1"].Votes[0].Status)
+	assert.Equal(t, 5.0, stored.users["1"].Score)
+	assert.Equal(t, "unmoved", cancelled.CoinValueSnapshotId)
+	assert.Len(t, stored.snapshots, 2)
+
+	// A void vote can not be cancelled again
+	_, err = CancelVote(context.Background(), "1", "placed")
+	assert.True(t, errors.Is(err, ErrCancelTooLate))
+
+	// Without a price recorded since, the vote keeps the snapshot it was placed at. Its stored time is
+	// truncated to the second, so it may have been placed up to a second later and is still in its grace.
+	again := vote("up", time.Now().Add(-CancelGrace).Truncate(time.Second))
+	again.Id, again.Status, again.CoinValueAtVoteSnapshotId = "again", con.VOTE_STATUS_PENDING, "at-vote"
+	stored.users["2"] = models.User{Id: "2", Votes: []models.Vote{again}}
+	stored.snapshots = nil
+
+	cancelled, err = CancelVote(context.Background(), "2", "again")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "at-vote", cancelled.CoinValueSnapshotId)
+}
+
+func TestCancelVoteRefused(t *testing.T) {
+	defer func(tolerance float64) { CancelToleranceBps = tolerance }(CancelToleranceBps)
+	late := vote("up", time.Now().Add(-CancelGrace-2*time.Second))
+	late.Id, late.Status = "late", con.VOTE_STATUS_PENDING
+	moved := vote("up", time.Now().Add(-time.Second))
+	moved.Id, moved.Status = "moved", con.VOTE_STATUS_PENDING
+	stored := setup(100, models.User{Id: "1", Votes: []models.Vote{late}}, models.User{Id: "2", Votes: []models.Vote{moved}})
+	stored.snapshots = []models.PriceSnapshot{snapshotAt("moved", 100.05, moved.VoteDateTime.Add(500*time.Millisecond))}
+
+	_, err := CancelVote(context.Background(), "1", "late")
+	assert.True(t, errors.Is(err, ErrCancelTooLate))
+
+	_, err = CancelVote(context.Background(), "2", "moved")
+	assert.True(t, errors.Is(err, ErrPriceMoved))
+
+	_, err = CancelVote(context.Background(), "2", "unknown")
+	assert.True(t, errors.Is(err, ErrVoteNotFound))
+	assert.Equal(t, 0, stored.updates)
+
+	// Within the tolerance, 10 basis points of 100, the price has not moved
+	CancelToleranceBps = 10
+	_, err = CancelVote(context.Background(), "2", "moved")
+	assert.NoError(t, err)
+}
+
+// racingDB changes the stored score of a user just before a vote is stored, like a concurrent request
+// in another process
+type racingDB struct {
+	*stubDB
+}
+
+func (r racingDB) race(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := r.copy(r.users[id])
+	stored.Score--
+	r.users[id] = stored
+}
+
+func (r racingDB) PlaceUserVote(ctx context.Context, user models.User, previousScore float64) (*models.User, error) {
+	r.race(user.Id)
+	return r.stubDB.PlaceUserVote(ctx, user, previousScore)
+}
+
+func (r racingDB) UpdateUserVote(ctx context.Context, user models.User, index int, previousStatus string, previousScore float64) (*models.User, error) {
+	r.race(user.Id)
+	return r.stubDB.UpdateUserVote(ctx, user, index, previousStatus, previousScore)
+}
+
+func TestPlaceVoteReservesStake(t *testing.T) {
+	stored := setup(100, models.User{Id: "1", Score: 10})
+	staked := vote("flat", time.Now())
+	staked.Id, staked.Status = "staked", con.VOTE_STATUS_PENDING
+
+	staked.Stake = 6
+	_, err := PlaceVote(context.Background(), "1", staked)
+	assert.True(t, errors.Is(err, ErrStakeTooHigh))
+	staked.Stake = 0.5
+	_, err = PlaceVote(context.Background(), "1", staked)
+	assert.True(t, errors.Is(err, ErrStakeTooLow))
+	staked.Stake = -5
+	_, err = PlaceVote(context.Background(), "1", staked)
+	assert.True(t, errors.Is(err, ErrStakeTooLow))
+	assert.Equal(t, 0, stored.updates)
+
+	staked.Stake = 5
+	_, err = PlaceVote(context.Background(), "1", staked)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, stored.users["1"].Score)
+	assert.Equal(t, StakeOdds[con.VOTE_DIRECTION_FLAT], stored.users["1"].Votes[0].StakeOdds)
+
+	// The running vote blocks another one
+	_, err = PlaceVote(context.Background(), "1", vote("up", time.Now()))
+	assert.True(t, errors.Is(err, ErrVoteInProgress))
+}
+
+func TestPlaceVoteRefusesChangedScore(t *testing.T) {
+	stored := setup(100, models.User{Id: "1", Score: 10})
+	db.DB = racingDB{stored}
+	staked := vote("up", time.Now())
+	staked.Stake = 5
+
+	_, err := PlaceVote(context.Background(), "1", staked)
+
+	assert.True(t, errors.Is(err, ErrScoreChanged))
+	assert.Empty(t, stored.users["1"].Votes)
+	assert.Equal(t, 9.0, stored.users["1"].Score)
+}
+
+func TestResolveRefusesChangedUser(t *testing.T) {
+	due := vote("up", time.Now().Add(-time.Minute-time.Second))
+	due.Id, due.Status = "due", con.VOTE_STATUS_PENDING
+	stored := setup(110, models.User{Id: "1", Score: 3, Votes: []models.Vote{due}})
+	db.DB = racingDB{stored}
+
+	_, err := ResolveVote(context.Background(), "1", "due")
+	assert.True(t, errors.Is(err, ErrScoreChanged))
+
+	// The resolver leaves it to the process that changed the user
+	count, err := ResolveDueVotes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, con.VOTE_STATUS_PENDING, stored.users["1"].Votes[0].Status)
+	assert.Equal(t, 1.0, stored.users["1"].Score)
+
+	// A vote placed after the user was read is not overwritten either
+	db.DB = stored
+	stored.users["1"] = models.User{Id: "1", Score: 3, Votes: []models.Vote{due, vote("up", time.Now())}}
+	user := models.User{Id: "1", Score: 3, Votes: []models.Vote{due}}
+	assert.ErrorIs(t, store(context.Background(), &user, &user.Votes[0], con.VOTE_STATUS_PENDING, 3), ErrScoreChanged)
+	assert.Len(t, stored.users["1"].Votes, 2)
+}
+
+func TestStakedVotePayout(t *testing.T) {
+	up, flat := vote("up", time.Time{}), vote("flat", time.Time{})
+	up.Stake, up.StakeOdds, flat.Stake, flat.StakeOdds = 10, 2, 10, 5
+
+	up.CoinValue = 101
+	assert.Equal(t, 10.0, ScoreChange(up))
+	assert.Equal(t, 20.0, Payout(up))
+	// Longer rounds pay more on the winnings
+	up.ScoreMultiplier = 1.5
+	assert.Equal(t, 15.0, ScoreChange(up))
+	up.CoinValue = 99
+	assert.Equal(t, -10.0, ScoreChange(up))
+	assert.Equal(t, 0.0, Payout(up))
+	// A flat market returns the stake of up and down votes, and pays out flat votes at their odds
+	up.CoinValue, flat.CoinValue = 100, 100
+	assert.Equal(t, 10.0, Payout(up))
+	assert.Equal(t, 50.0, Payout(flat))
+
+	// Resolving a won vote pays out into the score, which had the stake taken off when placing it
+	due := vote("up", time.Now().Add(-time.Minute-time.Second))
+	due.Id, due.Stake, due.StakeOdds = "due", 5, 2
+	stored := setup(110, models.User{Id: "1", Score: 5, Votes: []models.Vote{due}})
+
+	_, err := ResolveVote(context.Background(), "1", "due")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 15.0, stored.users["1"].Score)
+}
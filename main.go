@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -10,10 +12,14 @@ import (
 	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
 	"github.com/gin-gonic/gin"
 
+	"hermes-crypto-core/internal/auth"
+	"hermes-crypto-core/internal/coin"
 	"hermes-crypto-core/internal/db"
 	"hermes-crypto-core/internal/handlers/coins"
 	"hermes-crypto-core/internal/handlers/users"
+	"hermes-crypto-core/internal/handlers/ws"
 	"hermes-crypto-core/internal/middleware"
+	"hermes-crypto-core/internal/votes"
 
 	"github.com/joho/godotenv"
 )
@@ -31,6 +37,9 @@ func setupRouter() *gin.Engine {
 	r.GET("users/:id/votes", users.GetUserVotesById)
 	r.POST("users/:id/votes", users.CreateUserVote)
 	r.GET("users/:id/votes/result", users.GetLastUserVoteResult)
+	r.GET("users/:id/votes/:voteId", users.GetUserVote)
+	r.GET("users/:id/votes/:voteId/result", users.GetUserVoteResult)
+	r.DELETE("users/:id/votes/:voteId", users.CancelUserVote)
 	// Health check
 	r.GET("users/health", users.HealthCheck)
 	// Users base
@@ -40,12 +49,30 @@ func setupRouter() *gin.Engine {
 	r.DELETE("users/:id", users.DeleteUser)
 
 	// Routes for the coins API
+	// Coin catalog
+	r.GET("coins", coins.GetCoins)
 	// Coin Results
-	r.GET("coins/btc", coins.GetCurrentBTCCoinValueInUSD)
+	r.GET("coins/:coin", coins.GetCurrentCoinValue)
+	r.GET("coins/:coin/summary", coins.GetCoinSummary)
+	r.GET("coins/:coin/history", coins.GetCoinHistory)
+	// Price provider diagnostics
+	r.GET("coins/providers/health", coins.ProviderHealthCheck)
+	r.GET("coins/providers/quotas", middleware.AdminMiddleware(), coins.ProviderQuotaCheck)
+	// Price snapshots used for votes
+	r.GET("coins/snapshots/:id", coins.GetPriceSnapshot)
 
 	return r
 }
 
+// setupStreamingRoutes adds the routes that keep connections open, which only work when running
+// as an HTTP server and not behind the Lambda proxy
+func setupStreamingRoutes(r *gin.Engine) {
+	// Live coin prices as Server-Sent Events
+	r.GET("coins/:coin/stream", coins.StreamCoinValue)
+	// Live vote events and coin prices over a WebSocket
+	r.GET("ws", ws.Connect)
+}
+
 func init() {
 	err := godotenv.Load()
 	if err != nil {
@@ -54,13 +81,41 @@ func init() {
 
 	// DB initialization
 	db.Init()
+	// Price provider chain initialization
+	coin.Init()
+	// Signing of user tokens
+	auth.Init()
+	// Background resolution of votes
+	votes.Init()
 
 	// Set up the Lambda proxy
 	ginLambda = ginadapter.New(setupRouter())
 }
 
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	response, _ := ginLambda.Proxy(request)
+// handler serves both API Gateway requests and the EventBridge schedule that resolves votes whose
+// window has passed, so a single function covers the API and the resolver
+func handler(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var scheduled events.EventBridgeEvent
+	if err := json.Unmarshal(payload, &scheduled); err == nil && scheduled.DetailType == "Scheduled Event" {
+		resolved, err := votes.ResolveDueVotes(ctx)
+		if err != nil {
+			log.Printf("Vote resolver failed: %v", err)
+			return nil, err
+		}
+		log.Printf("Vote resolver resolved %d votes", resolved)
+		return map[string]int{"resolved": resolved}, nil
+	}
+
+	var request events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+	// The context carries the Lambda deadline through to the handlers
+	response, err := ginLambda.ProxyWithContext(ctx, request)
+	if err != nil {
+		log.Printf("Proxying API Gateway request failed: %v", err)
+		return nil, err
+	}
 	return response, nil
 }
 
@@ -72,7 +127,16 @@ func main() {
 		lambda.Start(handler)
 	} else {
 		log.Printf("Starting HTTP server on port %s", httpPort)
+		// A long running server can keep the live price feed Init configured open, which Lambda can not
+		if coin.StartStream(context.Background()) {
+			log.Println("Opened the Binance price stream")
+		}
+		// Without a schedule to invoke it, the server resolves votes itself
+		if os.Getenv("VOTE_RESOLVER") != "false" {
+			votes.StartResolver(context.Background())
+		}
 		r := setupRouter()
+		setupStreamingRoutes(r)
 		formattedPort := fmt.Sprintf(":%s", httpPort)
 		r.Run(formattedPort)
 	}